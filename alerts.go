@@ -2,40 +2,63 @@ package main
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
 type URL string
 type MessageID string
 
-type geocodeDescription struct {
-	ValueName string `json:"valueName"`
-	Value     string `json:"value"`
+// This file contains the CAP 1.2 data model, see http://docs.oasis-open.org/emergency/cap/v1.2/CAP-v1.2-os.html. JSON field
+// names follow the rendering served by warnung.bund.de, XML element names the CAP standard.
+
+// valuePair is a CAP key-value item, used for eventCode, parameter and geocode elements.
+type valuePair struct {
+	ValueName string `json:"valueName" xml:"valueName"`
+	Value     string `json:"value" xml:"value"`
 }
 
 type areaDescription struct {
-	Description string               `json:"areaDesc"`
-	Polygon     []string             `json:"polygon"` // TODO: Add proper extraction
-	Geocode     []geocodeDescription `json:"geocode"`
+	Description string      `json:"areaDesc" xml:"areaDesc"`
+	Polygon     []string    `json:"polygon" xml:"polygon"` // "lon,lat" points, e.g. "7.8,50.1 7.9,50.1 7.9,50.2 7.8,50.1", CAP XML uses "lat,lon"
+	Circle      []string    `json:"circle,omitempty" xml:"circle"`
+	Geocode     []valuePair `json:"geocode" xml:"geocode"`
+	Altitude    *float64    `json:"altitude,omitempty" xml:"altitude"` // in feet above mean sea level
+	Ceiling     *float64    `json:"ceiling,omitempty" xml:"ceiling"`   // in feet above mean sea level, requires Altitude
+}
+
+// resource refers to additional information like an image or audio file
+type resource struct {
+	Description string `json:"resourceDesc" xml:"resourceDesc"`
+	MimeType    string `json:"mimeType" xml:"mimeType"`
+	Size        int64  `json:"size,omitempty" xml:"size"` // in bytes
+	URI         string `json:"uri,omitempty" xml:"uri"`
+	DerefURI    string `json:"derefUri,omitempty" xml:"derefUri"` // base64 encoded content
+	Digest      string `json:"digest,omitempty" xml:"digest"`     // SHA-1 hash of the content
 }
 
 type infoItem struct {
-	Language           string            `json:"language"`
-	Category           []string          `json:"category"`     // e.g. []{"Safety"} or []{"Met"} for meterological messages
-	Event              string            `json:"event"`        // e.g. "Gefahrenmitteilung", "Gefahreninformation", sometimes just a code
-	ResponseType       []string          `json:"responseType"` // suggested response e.g. []{"Monitor", "Prepare"}
-	Urgency            string            `json:"urgency"`      // e.g. "Immediate"
-	Severity           string            `json:"severity"`     // e.g. "Minor"
-	Certainty          string            `json:"certainty"`    // How certain is this message? "Observed"
-	Headline           string            `json:"headline"`
-	Description        string            `json:"description"`
-	Instructions       string            `json:"instruction"`
-	ContactInformation string            `json:"contact"`
-	URL                URL               `json:"web"`
-	Area               []areaDescription `json:"area"` // List of affected areas
-	Expires            time.Time         `json:"expires"`
-
-	// TODO: parameter: list of key-value metadata items
+	Language           string            `json:"language" xml:"language"`
+	Category           []string          `json:"category" xml:"category"`         // e.g. []{"Safety"} or []{"Met"} for meterological messages
+	Event              string            `json:"event" xml:"event"`               // e.g. "Gefahrenmitteilung", "Gefahreninformation", sometimes just a code
+	ResponseType       []string          `json:"responseType" xml:"responseType"` // suggested response e.g. []{"Monitor", "Prepare"}
+	Urgency            string            `json:"urgency" xml:"urgency"`           // e.g. "Immediate"
+	Severity           string            `json:"severity" xml:"severity"`         // e.g. "Minor"
+	Certainty          string            `json:"certainty" xml:"certainty"`       // How certain is this message? "Observed"
+	Audience           string            `json:"audience,omitempty" xml:"audience"`
+	EventCode          []valuePair       `json:"eventCode,omitempty" xml:"eventCode"` // e.g. the DWD event type as {"II", "31"}
+	Effective          *time.Time        `json:"effective,omitempty" xml:"effective"` // When the information becomes effective, defaults to Sent
+	Onset              *time.Time        `json:"onset,omitempty" xml:"onset"`         // Expected beginning of the subject event
+	Expires            time.Time         `json:"expires" xml:"expires"`
+	SenderName         string            `json:"senderName,omitempty" xml:"senderName"` // Human readable name of the sender
+	Headline           string            `json:"headline" xml:"headline"`
+	Description        string            `json:"description" xml:"description"`
+	Instructions       string            `json:"instruction" xml:"instruction"`
+	URL                URL               `json:"web" xml:"web"`
+	ContactInformation string            `json:"contact" xml:"contact"`
+	Parameter          []valuePair       `json:"parameter,omitempty" xml:"parameter"` // e.g. the DWD warning level as {"warnLevel", "3"}
+	Resource           []resource        `json:"resource,omitempty" xml:"resource"`
+	Area               []areaDescription `json:"area" xml:"area"` // List of affected areas
 }
 
 func (i infoItem) String() string {
@@ -44,18 +67,76 @@ func (i infoItem) String() string {
 }
 
 type alertMessage struct {
-	Identifier MessageID `json:"identifier"`
-	Sender     string    `json:"sender"`
-	Sent       time.Time `json:"sent"`    // Timestamp
-	Status     string    `json:"status"`  // Is this a current message?
-	MsgType    string    `json:"msgType"` // "Cancel", "Alert"
-	Scope      string    `json:"scope"`   // e.g. "Public"
+	Identifier  MessageID `json:"identifier" xml:"identifier"`
+	Sender      string    `json:"sender" xml:"sender"`
+	Sent        time.Time `json:"sent" xml:"sent"`                         // Timestamp
+	Status      string    `json:"status" xml:"status"`                     // Is this a current message?
+	MsgType     string    `json:"msgType" xml:"msgType"`                   // "Cancel", "Alert"
+	Source      string    `json:"source,omitempty" xml:"source"`           // Text identifying the originator, e.g. an operator
+	Scope       string    `json:"scope" xml:"scope"`                       // e.g. "Public"
+	Restriction string    `json:"restriction,omitempty" xml:"restriction"` // Rule for limiting distribution of "Restricted" messages
+	Addresses   string    `json:"addresses,omitempty" xml:"addresses"`     // Recipients of "Private" messages
+	Code        []string  `json:"code,omitempty" xml:"code"`               // Special handling codes, e.g. "id:1.0:..." for MoWaS
+	Note        string    `json:"note,omitempty" xml:"note"`
+	References  string    `json:"references" xml:"references"`         // Earlier messages updated or cancelled by this one
+	Incidents   string    `json:"incidents,omitempty" xml:"incidents"` // Related incidents
 
-	// `code` is ignored here
-	Info []infoItem `json:"info"`
+	Info []infoItem `json:"info" xml:"info"`
+
+	// Feed is not part of CAP, it names the proxy source this message was received from.
+	Feed SourceName `json:"feed,omitempty" xml:"-"`
 }
 
 func (m alertMessage) String() string {
-	return fmt.Sprintf("[Identifier: %s, Sender: %s, Sent: %s, Status: %s, MsgType: %s, Scope: %s, Info: %s]",
-		m.Identifier, m.Sender, m.Sent, m.Status, m.MsgType, m.Scope, m.Info)
+	return fmt.Sprintf("[Identifier: %s, Feed: %s, Sender: %s, Sent: %s, Status: %s, MsgType: %s, Scope: %s, Info: %s]",
+		m.Identifier, m.Feed, m.Sender, m.Sent, m.Status, m.MsgType, m.Scope, m.Info)
+}
+
+const (
+	msgTypeUpdate = "Update"
+	msgTypeCancel = "Cancel"
+)
+
+// referencedIDs returns the identifiers of the messages referenced by m. CAP references are a space separated list of
+// "sender,identifier,sent" triples, but some feeds only list bare identifiers.
+func (m alertMessage) referencedIDs() []MessageID {
+	var ids []MessageID
+	for _, ref := range strings.Fields(m.References) {
+		parts := strings.Split(ref, ",")
+		switch len(parts) {
+		case 1:
+			ids = append(ids, MessageID(parts[0]))
+		case 3:
+			ids = append(ids, MessageID(parts[1]))
+		}
+	}
+	return ids
+}
+
+// expires returns the time at which m expires, i.e. the latest expiry of its info blocks. It returns the zero time if m never
+// expires.
+func (m alertMessage) expires() time.Time {
+	var t time.Time
+	for _, info := range m.Info {
+		if info.Expires.IsZero() {
+			return time.Time{}
+		}
+		if info.Expires.After(t) {
+			t = info.Expires
+		}
+	}
+	return t
+}
+
+// expired returns true if all info blocks of m have expired at now. Messages with an info block without expiry never expire.
+func (m alertMessage) expired(now time.Time) bool {
+	if len(m.Info) == 0 {
+		return false
+	}
+	for _, info := range m.Info {
+		if info.Expires.IsZero() || info.Expires.After(now) {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The files in testdata contain hand-written sample feeds in the format served by warnung.bund.de, using every field of the data
+// model. Responses recorded from the real feeds are kept in testdata/recorded, see testdata/recorded/README.md.
+var _testFeeds = []string{
+	"testdata/mowas.json",
+	"testdata/dwd.json",
+}
+
+// testFeeds returns the sample feeds and all recorded bbk-json feeds.
+func testFeeds(t *testing.T) []string {
+	recorded, err := filepath.Glob("testdata/recorded/bbk-*.json")
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	return append(_testFeeds[:len(_testFeeds):len(_testFeeds)], recorded...)
+}
+
+// containsJSON returns a description of the first value of want that is missing from or different in have. It returns "" if all
+// values of want are present in have. Empty strings, arrays and null values in want may be omitted in have.
+func containsJSON(path string, want, have interface{}) string {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		h, ok := have.(map[string]interface{})
+		if !ok {
+			return path + ": not an object"
+		}
+		for k, wv := range w {
+			hv, ok := h[k]
+			if !ok {
+				if isEmptyJSON(wv) {
+					continue
+				}
+				return path + "." + k + ": missing"
+			}
+			if d := containsJSON(path+"."+k, wv, hv); d != "" {
+				return d
+			}
+		}
+		return ""
+	case []interface{}:
+		h, ok := have.([]interface{})
+		if !ok || len(h) != len(w) {
+			return path + ": arrays differ"
+		}
+		for i := range w {
+			if d := containsJSON(path+"[]", w[i], h[i]); d != "" {
+				return d
+			}
+		}
+		return ""
+	default:
+		if !reflect.DeepEqual(want, have) {
+			return path + ": values differ"
+		}
+		return ""
+	}
+}
+
+func isEmptyJSON(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+func TestAlertRoundTrip(t *testing.T) {
+	for _, path := range testFeeds(t) {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal("can't read test feed:", err)
+		}
+
+		var alerts []alertMessage
+		if err := json.Unmarshal(content, &alerts); err != nil {
+			t.Fatal(path, "unexpected error", err)
+		}
+		encoded, err := json.Marshal(alerts)
+		if err != nil {
+			t.Fatal(path, "unexpected error", err)
+		}
+
+		var decoded []alertMessage
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatal(path, "unexpected error", err)
+		}
+		if !reflect.DeepEqual(alerts, decoded) {
+			t.Error(path, "alerts changed on round trip")
+		}
+
+		// Make sure no part of the original feed got lost
+		var original, reencoded interface{}
+		json.Unmarshal(content, &original)
+		json.Unmarshal(encoded, &reencoded)
+		if d := containsJSON("", original, reencoded); d != "" {
+			t.Error(path, "data lost on round trip:", d)
+		}
+	}
+}
+
+func TestAlertFields(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/mowas.json")
+	if err != nil {
+		t.Fatal("can't read test feed:", err)
+	}
+	var alerts []alertMessage
+	if err := json.Unmarshal(content, &alerts); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	info := alerts[0].Info[0]
+	if info.Onset == nil || !info.Onset.Equal(time.Date(2020, 1, 22, 15, 0, 0, 0, time.UTC)) {
+		t.Error("unexpected onset", info.Onset)
+	}
+	if len(info.Parameter) != 2 || info.Parameter[0].ValueName != "sender_langname" {
+		t.Error("unexpected parameters", info.Parameter)
+	}
+	if len(info.Resource) != 1 || info.Resource[0].Size != 48213 {
+		t.Error("unexpected resources", info.Resource)
+	}
+	area := info.Area[1]
+	if len(area.Circle) != 1 || area.Ceiling == nil || *area.Ceiling != 3000 {
+		t.Error("unexpected area", area)
+	}
+	if alerts[1].referencedIDs()[0] != alerts[0].Identifier {
+		t.Error("unexpected references", alerts[1].References)
+	}
+}
+
+func TestAlertExpired(t *testing.T) {
+	now := time.Now()
+	m := alertMessage{Info: []infoItem{{Expires: now.Add(-time.Hour)}, {Expires: now.Add(-time.Minute)}}}
+	if !m.expired(now) {
+		t.Error("expected message with only expired info blocks to be expired")
+	}
+	m.Info = append(m.Info, infoItem{})
+	if m.expired(now) {
+		t.Error("expected message with an info block without expiry not to be expired")
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// This file contains the decoder for Atom and RSS feeds that index CAP XML documents.
+
+const (
+	// formatCAPAtom is an Atom or RSS feed whose entries link to CAP 1.2 XML documents
+	formatCAPAtom = "cap-atom"
+)
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomFeed struct {
+	Entries []struct {
+		ID      string     `xml:"id"`
+		Updated string     `xml:"updated"`
+		Links   []atomLink `xml:"link"`
+	} `xml:"entry"`
+}
+
+type rssFeed struct {
+	Items []struct {
+		GUID    string `xml:"guid"`
+		PubDate string `xml:"pubDate"`
+		Link    string `xml:"link"`
+	} `xml:"channel>item"`
+}
+
+// indexEntry is an entry of an Atom or RSS index
+type indexEntry struct {
+	id      string
+	updated string
+	link    string
+}
+
+// capLink returns the link of an Atom entry that most likely points to its CAP document.
+func capLink(links []atomLink) string {
+	best := ""
+	for _, l := range links {
+		switch {
+		case l.Type == "application/cap+xml":
+			return l.Href
+		case best == "" && (l.Rel == "" || l.Rel == "alternate"):
+			best = l.Href
+		}
+	}
+	if best == "" && len(links) > 0 {
+		best = links[0].Href
+	}
+	return best
+}
+
+// decodeIndex returns the entries of an Atom or RSS feed.
+func decodeIndex(content []byte) ([]indexEntry, error) {
+	var root struct {
+		XMLName xml.Name
+	}
+	dec := xml.NewDecoder(bytes.NewReader(content))
+	dec.CharsetReader = charsetReader
+	if err := dec.Decode(&root); err != nil {
+		return nil, err
+	}
+
+	var entries []indexEntry
+	dec = xml.NewDecoder(bytes.NewReader(content))
+	dec.CharsetReader = charsetReader
+
+	switch root.XMLName.Local {
+	case "feed":
+		var feed atomFeed
+		if err := dec.Decode(&feed); err != nil {
+			return nil, err
+		}
+		for _, e := range feed.Entries {
+			entries = append(entries, indexEntry{id: e.ID, updated: e.Updated, link: capLink(e.Links)})
+		}
+	case "rss":
+		var feed rssFeed
+		if err := dec.Decode(&feed); err != nil {
+			return nil, err
+		}
+		for _, i := range feed.Items {
+			id := i.GUID
+			if id == "" {
+				id = i.Link
+			}
+			entries = append(entries, indexEntry{id: id, updated: i.PubDate, link: i.Link})
+		}
+	default:
+		return nil, fmt.Errorf("unexpected root element <%s>, want <feed> or <rss>", root.XMLName.Local)
+	}
+
+	return entries, nil
+}
+
+// cachedEntry is a CAP document fetched for an index entry
+type cachedEntry struct {
+	updated string
+	alerts  []alertMessage
+}
+
+// failedEntry is an index entry whose CAP document could not be fetched
+type failedEntry struct {
+	updated string
+	health  sourceHealth
+	retry   time.Time // The document is not fetched again before, unless the entry is updated
+}
+
+// atomDecoder decodes Atom and RSS indexes of CAP documents. The documents of new or updated entries are fetched, all other entries
+// are served from a cache. Entries failing to fetch are retried with a backoff of their own, so a single broken entry doesn't
+// delay the others.
+type atomDecoder struct {
+	base     *url.URL
+	log      *logrus.Entry
+	interval time.Duration
+	cache    map[string]cachedEntry
+	failed   map[string]failedEntry
+}
+
+func newAtomDecoder(src Source, log *logrus.Entry) decoder {
+	base, err := url.Parse(string(src.URL))
+	if err != nil {
+		// Relative links can't be resolved, absolute links still work
+		base = &url.URL{}
+	}
+	d := &atomDecoder{
+		base:     base,
+		log:      log,
+		interval: src.interval(),
+		cache:    make(map[string]cachedEntry),
+		failed:   make(map[string]failedEntry),
+	}
+	return d.decode
+}
+
+func (d *atomDecoder) decode(content []byte) ([]alertMessage, error) {
+	entries, err := decodeIndex(content)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	cache := make(map[string]cachedEntry)
+	failures := make(map[string]failedEntry)
+	var alerts []alertMessage
+	for _, e := range entries {
+		log := d.log.WithFields(logrus.Fields{
+			"entry": e.id,
+			"link":  e.link,
+		})
+
+		cached, ok := d.cache[e.id]
+		if !ok || cached.updated != e.updated {
+			f, failing := d.failed[e.id]
+			if failing && f.updated == e.updated && now.Before(f.retry) {
+				// Keep the previous version of the entry, if there is one, until the next retry
+				failures[e.id] = f
+				if !ok {
+					continue
+				}
+			} else if fetched, err := d.fetchEntry(e); err != nil {
+				if f.updated != e.updated {
+					f = failedEntry{updated: e.updated}
+				}
+				f.health.failed(now, err)
+				f.retry = now.Add(f.health.backoff(d.interval, _maxBackoff))
+				failures[e.id] = f
+				log.WithFields(logrus.Fields{
+					"failures": f.health.Failures,
+					"retry":    f.retry,
+					"err":      err,
+				}).Warn("failed to fetch CAP document")
+				if !ok {
+					continue
+				}
+			} else {
+				log.Debug("fetched CAP document")
+				cached = cachedEntry{updated: e.updated, alerts: fetched}
+			}
+		}
+
+		cache[e.id] = cached
+		alerts = append(alerts, cached.alerts...)
+	}
+	// Entries that are no longer part of the index are dropped from the cache
+	d.cache = cache
+	d.failed = failures
+
+	if len(failures) > 0 {
+		return alerts, fmt.Errorf("failed to fetch %d of %d entries: %w", len(failures), len(entries), errIncomplete)
+	}
+	return alerts, nil
+}
+
+// fetchEntry fetches and decodes the CAP document of e.
+func (d *atomDecoder) fetchEntry(e indexEntry) ([]alertMessage, error) {
+	if e.link == "" {
+		return nil, fmt.Errorf("entry %s has no link", e.id)
+	}
+	ref, err := url.Parse(e.link)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Get(d.base.ResolveReference(ref).String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeCAPXML(content)
+}
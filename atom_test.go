@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAtomDecoder(t *testing.T) {
+	doc, err := ioutil.ReadFile("testdata/dwd.xml")
+	if err != nil {
+		t.Fatal("can't read test feed:", err)
+	}
+
+	fetches := make(map[string]int)
+	failing := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches[r.URL.Path]++
+		if r.URL.Path == "/broken.xml" && failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(doc)
+	}))
+	defer srv.Close()
+
+	index := func(updated string) []byte {
+		return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <entry>
+    <id>a</id>
+    <updated>%s</updated>
+    <link rel="self" href="/self"/>
+    <link rel="alternate" type="application/cap+xml" href="/a.xml"/>
+  </entry>
+  <entry>
+    <id>b</id>
+    <updated>2020-01-22T16:26:00+01:00</updated>
+    <link href="%s/broken.xml"/>
+  </entry>
+</feed>`, updated, srv.URL))
+	}
+
+	// Retry the broken entry right away
+	src := Source{Name: "test", URL: URL(srv.URL + "/index.atom"), Format: formatCAPAtom, PollInterval: Duration(time.Millisecond)}
+	decode := newAtomDecoder(src, testLog)
+
+	alerts, err := decode(index("2020-01-22T16:26:00+01:00"))
+	if !errors.Is(err, errIncomplete) {
+		t.Error("expected errIncomplete for broken entry, got", err)
+	}
+	if len(alerts) != 1 {
+		t.Error("expected one alert, got", alerts)
+	}
+
+	failing = false
+	time.Sleep(2 * time.Millisecond)
+	alerts, err = decode(index("2020-01-22T16:26:00+01:00"))
+	if err != nil {
+		t.Error("unexpected error", err)
+	}
+	if len(alerts) != 2 {
+		t.Error("expected two alerts, got", alerts)
+	}
+	if fetches["/a.xml"] != 1 || fetches["/broken.xml"] != 2 {
+		t.Error("expected cached entry not to be fetched again, got", fetches)
+	}
+
+	// Updated entries are fetched again
+	if _, err = decode(index("2020-01-22T17:00:00+01:00")); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if fetches["/a.xml"] != 2 || fetches["/broken.xml"] != 2 {
+		t.Error("expected only updated entry to be fetched again, got", fetches)
+	}
+}
+
+func TestAtomDecoderRetry(t *testing.T) {
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	index := func(updated string) []byte {
+		return []byte(fmt.Sprintf(`<feed xmlns="http://www.w3.org/2005/Atom">
+  <entry><id>a</id><updated>%s</updated><link href="/a.xml"/></entry>
+</feed>`, updated))
+	}
+
+	decode := newAtomDecoder(Source{Name: "test", URL: URL(srv.URL + "/index.atom"), Format: formatCAPAtom, PollInterval: Duration(time.Hour)}, testLog)
+	for i := 0; i < 3; i++ {
+		if _, err := decode(index("1")); !errors.Is(err, errIncomplete) {
+			t.Error("expected errIncomplete for broken entry, got", err)
+		}
+	}
+	if fetches != 1 {
+		t.Error("expected broken entry not to be fetched again before its retry, got", fetches)
+	}
+
+	// Updated entries are fetched right away
+	if _, err := decode(index("2")); !errors.Is(err, errIncomplete) {
+		t.Error("expected errIncomplete for broken entry, got", err)
+	}
+	if fetches != 2 {
+		t.Error("expected updated entry to be fetched again, got", fetches)
+	}
+}
+
+func TestDecodeIndexRSS(t *testing.T) {
+	entries, err := decodeIndex([]byte(`<rss version="2.0"><channel>
+  <item><guid>urn:oid:1</guid><link>https://example.com/1.xml</link><pubDate>Wed, 22 Jan 2020 16:26:00 +0100</pubDate></item>
+  <item><link>https://example.com/2.xml</link></item>
+</channel></rss>`))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if len(entries) != 2 {
+		t.Fatal("expected two entries, got", entries)
+	}
+	if entries[0].id != "urn:oid:1" || entries[0].link != "https://example.com/1.xml" {
+		t.Error("unexpected entry", entries[0])
+	}
+	if entries[1].id != "https://example.com/2.xml" {
+		t.Error("expected link as ID for entry without guid, got", entries[1])
+	}
+
+	if _, err := decodeIndex([]byte(`<alert></alert>`)); err == nil {
+		t.Error("expected error for CAP document")
+	}
+}
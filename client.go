@@ -0,0 +1,746 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"nhooyr.io/websocket"
+)
+
+// This file contains the handling of websocket clients.
+
+// Client wraps a proxy reference and a logger for convenient access in the client's main loop
+type Client struct {
+	p   *Proxy
+	log atomic.Value
+	// version is the protocol version negotiated with the client
+	version int
+	// locations are the named locations the client watches. Legacy clients only send bare locations, which are stored under the
+	// empty name. Only accessed by the active alert watcher of the client.
+	locations map[string]Location
+	// viewport is the region of a map shown by the client, nil if the client did not subscribe to one. Only accessed by the active
+	// alert watcher of the client.
+	viewport *Area
+	// filter restricts the alerts sent to the client, nil if the client receives all alerts. Only accessed by the active alert
+	// watcher of the client.
+	filter *alertFilter
+	// delivered are the alerts last sent to the client. Only accessed by the active alert watcher of the client.
+	delivered map[alertKey]matchedAlert
+
+	// lastSeen holds the time.Time the client was last known to be alive, i.e. sent a message or answered a ping
+	lastSeen atomic.Value
+	// cancel aborts all reads and writes on the connection of the client
+	cancel         context.CancelFunc
+	disconnectOnce sync.Once
+	// reason is the reason for closing the connection, set by disconnect
+	reason string
+}
+
+// Reasons for closing client connections
+const (
+	disconnectClosed       = "closed" // Closed by the client
+	disconnectReadError    = "read error"
+	disconnectWriteError   = "write error"
+	disconnectPingTimeout  = "ping timeout"
+	disconnectReadTimeout  = "read timeout"
+	disconnectWriteTimeout = "write timeout"
+	disconnectSlow         = "slow consumer" // Send queue full with -slowClients=disconnect
+)
+
+// alertKey identifies an alert across all sources
+type alertKey struct {
+	feed SourceName
+	id   MessageID
+}
+
+func (m matchedAlert) key() alertKey {
+	return alertKey{m.Feed, m.Identifier}
+}
+
+func newClient(p *Proxy, version int) *Client {
+	return &Client{
+		p:         p,
+		version:   version,
+		locations: make(map[string]Location),
+	}
+}
+
+func (c *Client) Log() *logrus.Entry {
+	return c.log.Load().(*logrus.Entry)
+}
+
+func (c *Client) SetLog(l *logrus.Entry) {
+	c.log.Store(l)
+}
+
+// Location is a coordinate sent by a client. Accuracy is the optional radius of uncertainty of the coordinate in meters, as reported
+// by the location services of phones.
+type Location struct {
+	Coordinate
+	Accuracy float64 `json:",omitempty"`
+}
+
+// radius returns the accuracy of l in km, capped to -maxAccuracy. Invalid accuracies are ignored.
+func (l Location) radius() float64 {
+	if !(l.Accuracy > 0) {
+		// Negative or NaN
+		return 0
+	}
+	return math.Min(l.Accuracy, _maxAccuracy) / 1000
+}
+
+// seen marks the client as alive.
+func (c *Client) seen() {
+	c.lastSeen.Store(time.Now())
+}
+
+// idle returns the time since the client was last seen alive.
+func (c *Client) idle() time.Duration {
+	return time.Since(c.lastSeen.Load().(time.Time))
+}
+
+// disconnect closes the connection of the client for reason. Only the first reason is kept, later ones are consequences of it.
+func (c *Client) disconnect(reason string, err error) {
+	c.disconnectOnce.Do(func() {
+		c.reason = reason
+		c.Log().WithFields(logrus.Fields{
+			"reason": reason,
+			"err":    err,
+		}).Debug("Disconnecting")
+		if c.cancel != nil {
+			c.cancel()
+		}
+	})
+}
+
+// keepalive pings the client every -pingInterval and disconnects it if it doesn't answer within -pingTimeout or wasn't seen alive
+// for -readTimeout. It returns when ctx is done.
+func (c *Client) keepalive(ctx context.Context, conn *websocket.Conn) {
+	tick := _pingInterval
+	if tick <= 0 {
+		tick = _readTimeout
+	}
+	if tick <= 0 {
+		return
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _pingInterval > 0 {
+			// Don't pass the timeout to Ping, it closes the connection on timeout before the reason is recorded
+			pong := make(chan error, 1)
+			go func() {
+				pong <- conn.Ping(ctx)
+			}()
+			select {
+			case err := <-pong:
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					c.disconnect(disconnectPingTimeout, err)
+					return
+				}
+				c.seen()
+			case <-time.After(_pingTimeout):
+				c.disconnect(disconnectPingTimeout, errors.New("no pong received"))
+				return
+			}
+		}
+
+		if _readTimeout > 0 && c.idle() > _readTimeout {
+			c.disconnect(disconnectReadTimeout, nil)
+			return
+		}
+	}
+}
+
+// header returns the envelope of an event of type t in the protocol version of the client. seq is the sequence number of the
+// snapshot the event describes, 0 for events not describing alerts.
+func (c *Client) header(t string, seq uint64) header {
+	h := header{Type: t, Seq: seq}
+	if seq != 0 {
+		h.Epoch = c.p.epoch
+	}
+	if c.version >= 2 {
+		h.Version = c.version
+	}
+	return h
+}
+
+// deliver remembers alerts as delivered to the client.
+func (c *Client) deliver(alerts []matchedAlert) {
+	c.delivered = make(map[alertKey]matchedAlert, len(alerts))
+	for _, m := range alerts {
+		c.delivered[m.key()] = m
+	}
+}
+
+// alerts returns an event with all alerts matching the subscription of the client. The alerts are remembered as delivered.
+func (c *Client) alerts() alertsEvent {
+	snap := c.p.snapshot()
+	matching := c.matchingAlerts(snap, time.Now())
+	c.deliver(matching)
+	return alertsEvent{header: c.header(eventAlerts, snap.seq), Alerts: matching}
+}
+
+// changes returns the events describing how the alerts matching the subscription of the client changed since they were last
+// delivered, or nil if nothing changed. Version 1 clients receive all matching alerts instead.
+func (c *Client) changes() []interface{} {
+	return c.changesAt(c.p.snapshot(), time.Now())
+}
+
+// resume returns the events the client missed since the snapshot with sequence number since in epoch, assuming its subscription
+// did not change in between. If that snapshot is no longer known or from an earlier run of the proxy, all matching alerts are
+// returned instead.
+func (c *Client) resume(since uint64, epoch string) []interface{} {
+	var history []*snapshot
+	if epoch == c.p.epoch {
+		history = c.p.snapshotsSince(since)
+	}
+	if history == nil {
+		c.Log().WithFields(logrus.Fields{
+			"since": since,
+			"epoch": epoch,
+		}).Info("Can't resume, sending all alerts")
+		return []interface{}{c.alerts()}
+	}
+
+	// Replay the changes as if the client had been connected all the time
+	c.deliver(c.matchingAlerts(history[0], history[0].published))
+	var events []interface{}
+	for _, snap := range history[1:] {
+		events = append(events, c.changesAt(snap, snap.published)...)
+	}
+	// Alerts may have expired since the last change
+	events = append(events, c.changes()...)
+
+	c.Log().WithFields(logrus.Fields{
+		"since":  since,
+		"events": len(events),
+	}).Info("Resumed")
+	return events
+}
+
+// changesAt returns the events describing how the alerts of snap matching the subscription of the client at now differ from the
+// delivered ones, and remembers them as delivered.
+//
+// An alert that was added, but references a delivered alert that is gone, is an update of that alert. CAP updates have an
+// identifier of their own, so clients would otherwise mistake them for new alerts.
+func (c *Client) changesAt(snap *snapshot, now time.Time) []interface{} {
+	matching := c.matchingAlerts(snap, now)
+
+	current := make(map[alertKey]matchedAlert, len(matching))
+	for _, m := range matching {
+		current[m.key()] = m
+	}
+
+	gone := make(map[alertKey]bool)
+	for k := range c.delivered {
+		if _, ok := current[k]; !ok {
+			gone[k] = true
+		}
+	}
+
+	added := alertsEvent{header: c.header(eventAdded, snap.seq), Alerts: make([]matchedAlert, 0)}
+	updated := alertsEvent{header: c.header(eventUpdated, snap.seq), Alerts: make([]matchedAlert, 0)}
+	for _, m := range matching {
+		old, ok := c.delivered[m.key()]
+		if ok {
+			if !reflect.DeepEqual(old, m) {
+				updated.Alerts = append(updated.Alerts, m)
+			}
+			continue
+		}
+
+		replaces := false
+		for _, id := range m.referencedIDs() {
+			k := alertKey{m.Feed, id}
+			if gone[k] {
+				delete(gone, k)
+				updated.Replaced = append(updated.Replaced, alertRef{Feed: k.feed, Identifier: k.id})
+				replaces = true
+			}
+		}
+		if replaces {
+			updated.Alerts = append(updated.Alerts, m)
+		} else {
+			added.Alerts = append(added.Alerts, m)
+		}
+	}
+
+	removed := removedEvent{header: c.header(eventRemoved, snap.seq)}
+	for k := range gone {
+		r := removedAlert{alertRef: alertRef{Feed: k.feed, Identifier: k.id}, Reason: reasonUnmatched}
+		if snap.retracted[k.id] {
+			r.Reason = reasonRetracted
+		} else if c.delivered[k].expired(now) {
+			r.Reason = reasonExpired
+		}
+		removed.Alerts = append(removed.Alerts, r)
+	}
+	sort.Slice(removed.Alerts, func(i, j int) bool {
+		a, b := removed.Alerts[i], removed.Alerts[j]
+		return a.Feed < b.Feed || (a.Feed == b.Feed && a.Identifier < b.Identifier)
+	})
+
+	c.delivered = current
+
+	if len(added.Alerts) == 0 && len(updated.Alerts) == 0 && len(removed.Alerts) == 0 {
+		return nil
+	}
+	if c.version < 2 {
+		return []interface{}{alertsEvent{header: c.header(eventAlerts, snap.seq), Alerts: matching}}
+	}
+
+	var events []interface{}
+	if len(removed.Alerts) > 0 {
+		events = append(events, removed)
+	}
+	if len(updated.Alerts) > 0 {
+		events = append(events, updated)
+	}
+	if len(added.Alerts) > 0 {
+		events = append(events, added)
+	}
+	return events
+}
+
+// handle applies the command msg to the subscription of the client. It returns the events to send in reply.
+func (c *Client) handle(msg clientMessage) ([]interface{}, error) {
+	if msg.Version != 0 && msg.Version != c.version {
+		return nil, fmt.Errorf("unsupported protocol version %d, the connection uses version %d", msg.Version, c.version)
+	}
+	if msg.Filter != nil {
+		if err := msg.Filter.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	// The filter applies to the alerts sent in reply, so it is set first and restored if the command fails
+	previous := c.filter
+	if msg.Filter != nil {
+		c.filter = msg.Filter
+	}
+	events, err := c.apply(msg)
+	if err != nil {
+		c.filter = previous
+		return nil, err
+	}
+	if msg.Filter != nil {
+		c.Log().WithField("filter", *msg.Filter).Info("Filter set")
+	}
+	return events, nil
+}
+
+// apply applies the command msg to the subscription of the client, except for its filter. It returns the events to send in reply.
+func (c *Client) apply(msg clientMessage) ([]interface{}, error) {
+	switch msg.Type {
+	case "":
+		if err := msg.Coordinate.validate(); err != nil {
+			return nil, err
+		}
+		c.locations[""] = msg.Location
+		c.SetLog(c.Log().WithField("coordinate", msg.Location))
+		c.Log().Info("Received new coordinate")
+		return []interface{}{c.alerts()}, nil
+	case cmdSubscribe:
+		if len(msg.Locations) > _maxLocations {
+			return nil, fmt.Errorf("too many locations, at most %d are allowed", _maxLocations)
+		}
+		for name, l := range msg.Locations {
+			if err := l.Coordinate.validate(); err != nil {
+				return nil, fmt.Errorf("location %q: %w", name, err)
+			}
+		}
+		viewport, err := msg.viewport()
+		if err != nil {
+			return nil, err
+		}
+		c.locations = make(map[string]Location, len(msg.Locations))
+		for name, l := range msg.Locations {
+			c.locations[name] = l
+		}
+		c.viewport = viewport
+		c.filter = msg.Filter
+		c.Log().WithField("locations", len(c.locations)).Info("Subscribed")
+		if msg.Since > 0 {
+			return c.resume(msg.Since, msg.Epoch), nil
+		}
+		return []interface{}{c.alerts()}, nil
+	case cmdAdd:
+		if msg.Name == "" {
+			return nil, errors.New("location name missing")
+		}
+		if err := msg.Coordinate.validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := c.locations[msg.Name]; !ok && len(c.locations) >= _maxLocations {
+			return nil, fmt.Errorf("too many locations, at most %d are allowed", _maxLocations)
+		}
+		c.locations[msg.Name] = msg.Location
+		c.Log().WithFields(logrus.Fields{
+			"name":       msg.Name,
+			"coordinate": msg.Location,
+		}).Info("Location added")
+		return []interface{}{c.alerts()}, nil
+	case cmdRemove:
+		if _, ok := c.locations[msg.Name]; !ok {
+			return nil, fmt.Errorf("unknown location %q", msg.Name)
+		}
+		delete(c.locations, msg.Name)
+		c.Log().WithField("name", msg.Name).Info("Location removed")
+		return []interface{}{c.alerts()}, nil
+	case cmdList:
+		return []interface{}{locationsEvent{header: c.header(eventLocations, 0), Locations: c.locations}}, nil
+	case cmdViewport:
+		viewport, err := msg.viewport()
+		if err != nil {
+			return nil, err
+		}
+		c.viewport = viewport
+		if viewport != nil {
+			c.Log().WithField("bounds", viewport.Bounds()).Info("Viewport set")
+		} else {
+			c.Log().Info("Viewport cleared")
+		}
+		return []interface{}{c.alerts()}, nil
+	case cmdFilter:
+		if msg.Filter == nil {
+			c.filter = nil
+			c.Log().Info("Filter cleared")
+		}
+		return []interface{}{c.alerts()}, nil
+	default:
+		return nil, fmt.Errorf("unknown command %q", msg.Type)
+	}
+}
+
+// watching returns true if the client subscribed to any location or viewport.
+func (c *Client) watching() bool {
+	return len(c.locations) > 0 || c.viewport != nil
+}
+
+// matchingAlerts returns all alerts of snap that have areas affecting any location or the viewport of the client at now and pass its
+// filter. Each alert is returned once, listing all locations it affects.
+func (c *Client) matchingAlerts(snap *snapshot, now time.Time) []matchedAlert {
+	names := make([]string, 0, len(c.locations))
+	for name := range c.locations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Match all locations against the same snapshot, so the result is consistent
+	// Create empty list. This doesn't use the `nil` pattern for new slices because those encode to `null` values in JSON.
+	matchingAlerts := make([]matchedAlert, 0)
+	seen := make(map[alertKey]int)
+	add := func(alert alertMessage) *matchedAlert {
+		if c.filter != nil && !c.filter.matches(alert) {
+			return nil
+		}
+		key := alertKey{alert.Feed, alert.Identifier}
+		i, ok := seen[key]
+		if !ok {
+			i = len(matchingAlerts)
+			seen[key] = i
+			matchingAlerts = append(matchingAlerts, matchedAlert{alertMessage: alert})
+		}
+		return &matchingAlerts[i]
+	}
+
+	for _, name := range names {
+		l := c.locations[name]
+		for _, alert := range snap.matchingAlerts(l.Coordinate, l.radius(), now) {
+			if m := add(alert); m != nil && name != "" {
+				m.Locations = append(m.Locations, name)
+			}
+		}
+	}
+	if c.viewport != nil {
+		for _, alert := range snap.intersectingAlerts(*c.viewport, now) {
+			if m := add(alert); m != nil {
+				m.Viewport = true
+			}
+		}
+	}
+
+	c.Log().WithField("count", len(matchingAlerts)).Debug("got matching alerts")
+	return matchingAlerts
+}
+
+// send writes v as a single JSON message to conn.
+func send(ctx context.Context, conn *websocket.Conn, v interface{}) error {
+	writer, err := conn.Writer(ctx, websocket.MessageText)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(writer).Encode(v); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// request is a message read from a client, or the error reading it
+type request struct {
+	msg clientMessage
+	err error
+}
+
+// readMessages decodes all client messages in the websocket message r. It returns the messages decoded before the first error.
+func readMessages(r io.Reader) ([]clientMessage, error) {
+	// Read the whole message first, the websocket reader fails when read again after EOF
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []clientMessage
+	dec := json.NewDecoder(bytes.NewReader(content))
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			return msgs, nil
+		}
+		var msg clientMessage
+		if err == nil {
+			err = json.Unmarshal(raw, &msg)
+		}
+		if err == nil {
+			err = checkCoordinates(msg.Type, raw)
+		}
+		if err != nil {
+			return msgs, fmt.Errorf("malformed message: %w", err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+// process handles req and returns the events to send in reply. Invalid requests are answered with an error event.
+func (c *Client) process(req request) []interface{} {
+	err := req.err
+	if err == nil {
+		var events []interface{}
+		events, err = c.handle(req.msg)
+		if err == nil {
+			return events
+		}
+	}
+
+	c.Log().WithField("type", req.msg.Type).Warn("Invalid request:", err)
+	return []interface{}{errorEvent{header: c.header(eventError, 0), Error: err.Error(), Command: req.msg.Type}}
+}
+
+// enqueue queues events for sending. If the queue is full, the client is treated according to -slowClients. It returns false if
+// the client was disconnected.
+func (c *Client) enqueue(q *sendQueue, events []interface{}) bool {
+	var messages []interface{}
+	for _, ev := range events {
+		messages = append(messages, encodeEvent(ev, c.version))
+	}
+	if len(messages) == 0 || q.push(messages...) {
+		return true
+	}
+
+	if _slowClients == slowDisconnect {
+		c.disconnect(disconnectSlow, nil)
+		return false
+	}
+
+	// The dropped events are outdated by the current state, which also keeps the client from missing any alert
+	dropped := q.replace(encodeEvent(c.alerts(), c.version))
+	c.Log().WithField("dropped", dropped+len(messages)).Warn("Client too slow, sending all alerts instead of queued events")
+	return true
+}
+
+// writeLoop sends the messages of q to conn until ctx is done. Writes taking longer than -writeTimeout disconnect the client.
+func (c *Client) writeLoop(ctx context.Context, conn *websocket.Conn, q *sendQueue) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.ready:
+		}
+
+		for {
+			msg, ok := q.pop()
+			if !ok {
+				break
+			}
+			// Not using a context with timeout, it closes the connection on timeout before the reason is recorded
+			deadline := time.AfterFunc(_writeTimeout, func() {
+				c.disconnect(disconnectWriteTimeout, nil)
+			})
+			err := send(ctx, conn, msg)
+			deadline.Stop()
+			if err != nil {
+				c.disconnect(disconnectWriteError, err)
+				return
+			}
+		}
+	}
+}
+
+// socketHandler runs a client connection
+func (p *Proxy) socketHandler(w http.ResponseWriter, r *http.Request) {
+	log := logrus.WithFields(logrus.Fields{
+		"component": "client",
+		"remote":    r.RemoteAddr,
+	})
+
+	if ok, err := _allowOrigins.allows(r); !ok {
+		log.WithField("origin", r.Header.Get("Origin")).Warn("Rejecting websocket:", err)
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		Subprotocols: subprotocols,
+		// The origin was checked above, the library only allows the host's own origin
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		log.Error("Failed to set up websocket:", err)
+		return
+	}
+	defer conn.Close(websocket.StatusInternalError, "internal server error")
+
+	client := newClient(p, protocolVersion(conn.Subprotocol()))
+	client.SetLog(log.WithField("version", client.version))
+	client.seen()
+	connected := time.Now()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	client.cancel = cancel
+
+	// When a user sends a command, update the locations and check active alerts on p
+	// Otherwise, watch the active alerts for new things
+	quit := make(chan interface{})
+	done := make(chan interface{})
+	requests := make(chan request)
+
+	// Buffered, so updates arriving while the client is busy are kept. Further updates are coalesced with the pending one.
+	updateChan := make(chan bool, 1)
+	p.registerUpdateChan(updateChan)
+	defer p.unregisterUpdateChan(updateChan)
+
+	// Detect dead connections, which would otherwise stay registered until the operating system gives up on them
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		client.keepalive(ctx, conn)
+	}()
+
+	// Write in a goroutine of its own, so slow clients don't delay the processing of updates
+	queue := newSendQueue(_sendQueueSize)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		client.writeLoop(ctx, conn, queue)
+	}()
+
+	// First part, run a goroutine to await changes on the active alerts
+	go func() {
+		defer close(done)
+
+		client.Log().Info("Waiting for changes in active alerts or locations")
+		for {
+			var events []interface{}
+			select {
+			case req := <-requests:
+				events = client.process(req)
+			case <-updateChan:
+				if client.watching() {
+					events = client.changes()
+				} else {
+					client.Log().Info("not checking update, no locations or viewport set")
+				}
+			case <-quit:
+				client.Log().Info("Active alert watcher quitting")
+				return
+			}
+
+			if !client.enqueue(queue, events) {
+				return
+			}
+		}
+	}()
+
+	// Consume from the websocket to gather new commands, exit on first error
+	for {
+		mt, reader, err := conn.Reader(ctx)
+		if err != nil {
+			if websocket.CloseStatus(err) != -1 {
+				client.disconnect(disconnectClosed, err)
+			} else {
+				client.disconnect(disconnectReadError, err)
+			}
+			break
+		}
+		client.seen()
+
+		var msgs []clientMessage
+		if mt != websocket.MessageText {
+			// Consume all non-text message and drop them
+			client.Log().Debug("Non-text message received")
+			_, err = io.Copy(ioutil.Discard, reader)
+			if err == nil {
+				err = errors.New("only text messages are supported")
+			}
+		} else {
+			msgs, err = readMessages(reader)
+		}
+
+		// Update locations and re-check active alerts
+		reqs := make([]request, 0, len(msgs)+1)
+		for _, msg := range msgs {
+			reqs = append(reqs, request{msg: msg})
+		}
+		if err != nil {
+			reqs = append(reqs, request{err: err})
+		}
+		for _, req := range reqs {
+			select {
+			case requests <- req:
+			case <-done:
+			}
+		}
+	}
+
+	// Signal goroutines that it's time to go
+	close(quit)
+	cancel()
+	// ... and wait for them to exit
+	<-done
+	wg.Wait()
+
+	p.countDisconnect(client.reason)
+	client.Log().WithFields(logrus.Fields{
+		"reason":   client.reason,
+		"duration": time.Since(connected),
+	}).Info("Client disconnected")
+	conn.Close(websocket.StatusNormalClosure, "")
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testClient(alerts ...alertMessage) *Client {
+	p := newProxy(nil, nil)
+	p.publish("test", newSourceSnapshot("test", alerts, nil, testLog))
+	c := newClient(p, 2)
+	c.SetLog(testLog)
+	return c
+}
+
+func TestClientLocations(t *testing.T) {
+	c := testClient(
+		testAlert("a", _testArea2),
+		testAlert("b", `2,2 4,2 4,4 2,4 2,2`),
+	)
+
+	commands := []string{
+		`{"type": "add", "name": "home", "Latitude": 0.5, "Longitude": 0.5}`,
+		`{"type": "add", "name": "work", "Latitude": 3, "Longitude": 3}`,
+		`{"type": "add", "name": "office", "Latitude": 0, "Longitude": 0}`,
+	}
+	for _, cmd := range commands {
+		var msg clientMessage
+		if err := json.Unmarshal([]byte(cmd), &msg); err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		if _, err := c.handle(msg); err != nil {
+			t.Fatal("unexpected error", err)
+		}
+	}
+
+	locations := make(map[MessageID][]string)
+	for _, alert := range c.matchingAlerts(c.p.snapshot(), time.Now()) {
+		locations[alert.Identifier] = alert.Locations
+	}
+	expected := map[MessageID][]string{
+		"a": {"home", "office"},
+		"b": {"work"},
+	}
+	if !reflect.DeepEqual(locations, expected) {
+		t.Error("unexpected matched locations", locations)
+	}
+
+	if _, err := c.handle(clientMessage{header: header{Type: cmdRemove}, Name: "work"}); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if alerts := c.matchingAlerts(c.p.snapshot(), time.Now()); len(alerts) != 1 || alerts[0].Identifier != "a" {
+		t.Error("expected only alert a after removing work, got", alerts)
+	}
+
+	reply, err := c.handle(clientMessage{header: header{Type: cmdList}})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if list := reply[0].(locationsEvent); len(list.Locations) != 2 || list.Locations["home"].Latitude != 0.5 {
+		t.Error("unexpected location list", list)
+	}
+}
+
+func TestClientInvalidCommands(t *testing.T) {
+	c := testClient()
+
+	invalid := []clientMessage{
+		{header: header{Type: cmdAdd}},
+		{header: header{Type: cmdRemove}, Name: "unknown"},
+		{header: header{Type: "bogus"}},
+	}
+	for _, msg := range invalid {
+		if _, err := c.handle(msg); err == nil {
+			t.Error("expected error for", msg)
+		}
+	}
+
+	for i := 0; i < _maxLocations; i++ {
+		c.locations[string(rune('a'+i))] = Location{}
+	}
+	if _, err := c.handle(clientMessage{header: header{Type: cmdAdd}, Name: "one too many"}); err == nil {
+		t.Error("expected error when exceeding the location limit")
+	}
+}
+
+func TestClientLegacyLocation(t *testing.T) {
+	c := testClient(testAlert("a", _testArea2))
+	c.version = 1
+
+	var msg clientMessage
+	if err := json.Unmarshal([]byte(`{"Latitude": 0.5, "Longitude": 0.5}`), &msg); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	reply, err := c.handle(msg)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	// Legacy clients receive the same alert messages as before
+	encoded, _ := json.Marshal(encodeEvent(reply[0], c.version))
+	var alerts []map[string]interface{}
+	if err := json.Unmarshal(encoded, &alerts); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if len(alerts) != 1 || alerts[0]["identifier"] != "a" {
+		t.Fatal("unexpected alerts", string(encoded))
+	}
+	if _, ok := alerts[0]["locations"]; ok {
+		t.Error("expected no locations for legacy clients")
+	}
+}
+
+func TestClientViewport(t *testing.T) {
+	c := testClient(
+		testAlert("a", _testArea2),
+		testAlert("b", `2,2 4,2 4,4 2,4 2,2`),
+	)
+
+	var msg clientMessage
+	if err := json.Unmarshal([]byte(`{"type": "viewport", "bbox": {"Min": {"Latitude": 0.5, "Longitude": 0.5}, "Max": {"Latitude": 2.5, "Longitude": 2.5}}}`), &msg); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	reply, err := c.handle(msg)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if alerts := reply[0].(alertsEvent).Alerts; len(alerts) != 2 || !alerts[0].Viewport || !alerts[1].Viewport {
+		t.Error("expected both alerts in viewport, got", alerts)
+	}
+
+	msg = clientMessage{header: header{Type: cmdViewport}, Polygon: []Coordinate{{-0.5, -0.5}, {-0.5, 0.5}, {0.5, -0.5}}}
+	if reply, _ := c.handle(msg); len(reply[0].(alertsEvent).Alerts) != 1 {
+		t.Error("expected only alert a in polygon viewport, got", reply)
+	}
+
+	if reply, _ := c.handle(clientMessage{header: header{Type: cmdViewport}}); len(reply[0].(alertsEvent).Alerts) != 0 || c.watching() {
+		t.Error("expected no alerts after clearing the viewport, got", reply)
+	}
+
+	invalid := []clientMessage{
+		{header: header{Type: cmdViewport}, Polygon: []Coordinate{{0, 0}, {1, 1}}},
+		{header: header{Type: cmdViewport}, BBox: &BoundingBox{Min: Coordinate{1, 1}, Max: Coordinate{0, 0}}},
+		{header: header{Type: cmdViewport}, BBox: &BoundingBox{}, Polygon: []Coordinate{{0, 0}, {1, 1}, {1, 0}}},
+	}
+	for _, msg := range invalid {
+		if _, err := c.handle(msg); err == nil {
+			t.Error("expected error for", msg)
+		}
+	}
+}
+
+func TestClientFilter(t *testing.T) {
+	minor := testAlert("minor", _testArea2)
+	minor.Info[0].Severity = "Minor"
+	severe := testAlert("severe", _testArea2)
+	severe.Info[0].Severity = "Severe"
+	c := testClient(minor, severe)
+
+	var msg clientMessage
+	if err := json.Unmarshal([]byte(`{"type": "add", "name": "home", "Latitude": 0, "Longitude": 0, "filter": {"severity": "Moderate"}}`), &msg); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	reply, err := c.handle(msg)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if alerts := reply[0].(alertsEvent).Alerts; len(alerts) != 1 || alerts[0].Identifier != "severe" {
+		t.Error("expected only the severe alert, got", alerts)
+	}
+
+	if reply, _ := c.handle(clientMessage{header: header{Type: cmdFilter}}); len(reply[0].(alertsEvent).Alerts) != 2 {
+		t.Error("expected all alerts after clearing the filter, got", reply)
+	}
+
+	if _, err := c.handle(clientMessage{header: header{Type: cmdFilter}, Filter: &alertFilter{Severity: "Terrible"}}); err == nil {
+		t.Error("expected error for invalid filter")
+	}
+}
+
+func TestClientChanges(t *testing.T) {
+	a := testAlert("a", _testArea2)
+	c := testClient(a)
+	publish := func(alerts ...alertMessage) {
+		c.p.publish("test", newSourceSnapshot("test", alerts, nil, testLog))
+	}
+
+	if _, err := c.handle(clientMessage{header: header{Type: cmdAdd}, Name: "home"}); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if events := c.changes(); events != nil {
+		t.Error("expected no events without changes, got", events)
+	}
+
+	// a changed, b is new. The info blocks of the published a must not be modified.
+	a = testAlert("a", _testArea2)
+	a.Info[0].Headline = "changed"
+	b := testAlert("b", _testArea2)
+	publish(a, b)
+	events := c.changes()
+	if len(events) != 2 {
+		t.Fatal("expected updated and added events, got", events)
+	}
+	if e := events[0].(alertsEvent); e.Type != eventUpdated || len(e.Alerts) != 1 || e.Alerts[0].Identifier != "a" {
+		t.Error("unexpected updated event", e)
+	}
+	if e := events[1].(alertsEvent); e.Type != eventAdded || len(e.Alerts) != 1 || e.Alerts[0].Identifier != "b" {
+		t.Error("unexpected added event", e)
+	}
+
+	// a2 supersedes a, b is gone
+	a2 := testAlert("a2", _testArea2)
+	a2.MsgType = msgTypeUpdate
+	a2.References = "sender,a,2020-01-22T15:00:00+01:00"
+	publish(a, a2)
+	events = c.changes()
+	if len(events) != 2 {
+		t.Fatal("expected removed and updated events, got", events)
+	}
+	if e := events[0].(removedEvent); len(e.Alerts) != 1 || e.Alerts[0].Identifier != "b" || e.Alerts[0].Reason != reasonUnmatched {
+		t.Error("unexpected removed event", e)
+	}
+	if e := events[1].(alertsEvent); e.Type != eventUpdated || len(e.Alerts) != 1 || e.Alerts[0].Identifier != "a2" ||
+		len(e.Replaced) != 1 || e.Replaced[0].Identifier != "a" {
+		t.Error("unexpected updated event", e)
+	}
+
+	// a2 is cancelled
+	cancel := testAlert("cancel", _testArea2)
+	cancel.MsgType = msgTypeCancel
+	cancel.References = "a2"
+	publish(a, a2, cancel)
+	events = c.changes()
+	if len(events) != 1 {
+		t.Fatal("expected removed event, got", events)
+	}
+	if e := events[0].(removedEvent); len(e.Alerts) != 1 || e.Alerts[0].Identifier != "a2" || e.Alerts[0].Reason != reasonRetracted {
+		t.Error("unexpected removed event", e)
+	}
+}
+
+func TestClientChangesLegacy(t *testing.T) {
+	c := testClient(testAlert("a", _testArea2))
+	c.version = 1
+	if _, err := c.handle(clientMessage{}); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if events := c.changes(); events != nil {
+		t.Error("expected no events without changes, got", events)
+	}
+
+	c.p.publish("test", newSourceSnapshot("test", []alertMessage{testAlert("a", _testArea2), testAlert("b", _testArea2)}, nil, testLog))
+	events := c.changes()
+	if len(events) != 1 {
+		t.Fatal("expected a single event, got", events)
+	}
+	if alerts, ok := encodeEvent(events[0], c.version).([]matchedAlert); !ok || len(alerts) != 2 {
+		t.Error("expected all alerts for legacy clients, got", events[0])
+	}
+}
+
+func TestClientResume(t *testing.T) {
+	c := testClient(testAlert("a", _testArea2))
+	subscribe := clientMessage{
+		header:    header{Type: cmdSubscribe},
+		Locations: map[string]Location{"home": {}},
+	}
+	reply, err := c.handle(subscribe)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	since, epoch := reply[0].(alertsEvent).Seq, reply[0].(alertsEvent).Epoch
+	if since != 1 || epoch != c.p.epoch {
+		t.Fatal("expected sequence number 1 of the proxy's epoch, got", since, epoch)
+	}
+
+	// While the client is offline, x is issued and cancelled
+	x := testAlert("x", _testArea2)
+	cancel := testAlert("cancel", _testArea2)
+	cancel.MsgType = msgTypeCancel
+	cancel.References = "x"
+	c.p.publish("test", newSourceSnapshot("test", []alertMessage{testAlert("a", _testArea2), x}, nil, testLog))
+	c.p.publish("test", newSourceSnapshot("test", []alertMessage{testAlert("a", _testArea2), x, cancel}, nil, testLog))
+
+	resumed := newClient(c.p, 2)
+	resumed.SetLog(testLog)
+	subscribe.Since, subscribe.Epoch = since, epoch
+	events, err := resumed.handle(subscribe)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if len(events) != 2 {
+		t.Fatal("expected added and removed events, got", events)
+	}
+	if e := events[0].(alertsEvent); e.Type != eventAdded || e.Seq != 2 || e.Alerts[0].Identifier != "x" {
+		t.Error("unexpected added event", e)
+	}
+	if e := events[1].(removedEvent); e.Seq != 3 || e.Alerts[0].Identifier != "x" || e.Alerts[0].Reason != reasonRetracted {
+		t.Error("unexpected removed event", e)
+	}
+
+	// Nothing missed
+	subscribe.Since = 3
+	if events, _ := resumed.handle(subscribe); len(events) != 0 {
+		t.Error("expected no events, got", events)
+	}
+
+	// Unknown cursors get all alerts, including known sequence numbers of another run of the proxy
+	for _, cursor := range []header{{Seq: 42, Epoch: epoch}, {Seq: 2, Epoch: "other"}, {Seq: 2}} {
+		subscribe.Since, subscribe.Epoch = cursor.Seq, cursor.Epoch
+		events, _ = resumed.handle(subscribe)
+		if e, ok := events[0].(alertsEvent); len(events) != 1 || !ok || e.Type != eventAlerts || e.Seq != 3 || len(e.Alerts) != 1 {
+			t.Error(cursor, "expected all alerts, got", events)
+		}
+	}
+}
+
+func TestProxyHistory(t *testing.T) {
+	defer func(size int) { _eventLogSize = size }(_eventLogSize)
+	_eventLogSize = 3
+
+	p := newProxy(nil, nil)
+	for i := 0; i < 5; i++ {
+		alert := testAlert(MessageID(rune('a'+i)), _testArea2)
+		p.publish("test", newSourceSnapshot("test", []alertMessage{alert}, nil, testLog))
+	}
+	// Unchanged alerts don't create a new snapshot
+	p.publish("test", newSourceSnapshot("test", []alertMessage{testAlert("e", _testArea2)}, nil, testLog))
+
+	if p.snapshot().seq != 5 {
+		t.Error("expected sequence number 5, got", p.snapshot().seq)
+	}
+	if s := p.snapshotsSince(2); s != nil {
+		t.Error("expected dropped snapshot not to be found, got", s)
+	}
+	if s := p.snapshotsSince(3); len(s) != 3 || s[0].seq != 3 || s[2].seq != 5 {
+		t.Error("unexpected snapshots", s)
+	}
+}
+
+func TestExpiryNotifiesClients(t *testing.T) {
+	alert := testAlert("a", _testArea2)
+	alert.Info[0].Expires = time.Now().Add(50 * time.Millisecond)
+	c := testClient(alert)
+	if _, err := c.handle(clientMessage{header: header{Type: cmdAdd}, Name: "home"}); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	ch := make(chan bool, 1)
+	c.p.registerUpdateChan(ch)
+	defer c.p.unregisterUpdateChan(ch)
+	go c.p.expiryLoop()
+
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected clients to be notified of the expired alert")
+	}
+	events := c.changes()
+	if e, ok := events[0].(removedEvent); len(events) != 1 || !ok || e.Alerts[0].Reason != reasonExpired {
+		t.Error("expected removed event, got", events)
+	}
+}
+
+func TestReadMessages(t *testing.T) {
+	msgs, err := readMessages(strings.NewReader(`{"type": "list"} {"type": "remove", "name": "home"}`))
+	if err != nil || len(msgs) != 2 || msgs[1].Name != "home" {
+		t.Error("unexpected messages", msgs, err)
+	}
+
+	valid := []string{
+		`{"Latitude": 0, "Longitude": 0}`,
+		`{"type": "add", "name": "home", "latitude": 50, "longitude": 8}`,
+		`{"type": "subscribe", "locations": {"home": {"Latitude": 0, "Longitude": 0}}}`,
+	}
+	for _, content := range valid {
+		if _, err := readMessages(strings.NewReader(content)); err != nil {
+			t.Errorf("%s: unexpected error %v", content, err)
+		}
+	}
+
+	malformed := []string{
+		`{"type": "list"`,
+		`{"Latitude": "north"}`,
+		`{"type": "list"} nonsense`,
+		// Missing or misspelled coordinates
+		`{}`,
+		`{"lat": 50, "lon": 8}`,
+		`{"Latitude": 50}`,
+		`{"type": "add", "name": "x"}`,
+		`{"type": "subscribe", "locations": {"home": {"Longitude": 8}}}`,
+	}
+	for _, content := range malformed {
+		if _, err := readMessages(strings.NewReader(content)); err == nil {
+			t.Errorf("%s: expected error", content)
+		}
+	}
+}
+
+func TestClientRejectsInvalidCoordinates(t *testing.T) {
+	c := testClient(testAlert("a", _testArea2))
+	c.filter = &alertFilter{Severity: "Minor"}
+
+	invalid := []clientMessage{
+		{Location: Location{Coordinate: Coordinate{Latitude: 91}}},
+		{header: header{Type: cmdAdd}, Name: "home", Location: Location{Coordinate: Coordinate{Longitude: math.NaN()}}},
+		{header: header{Type: cmdSubscribe}, Locations: map[string]Location{"home": {Coordinate: Coordinate{Latitude: -100}}}},
+		{header: header{Type: cmdViewport}, BBox: &BoundingBox{Min: Coordinate{0, 0}, Max: Coordinate{0, 200}}},
+		{header: header{Type: cmdViewport}, Polygon: []Coordinate{{0, 0}, {1, 1}, {95, 0}}},
+		// Valid filter, but the command fails, so the filter must not change
+		{header: header{Type: cmdAdd}, Name: "home", Location: Location{Coordinate: Coordinate{Latitude: 91}}, Filter: &alertFilter{}},
+	}
+	for _, msg := range invalid {
+		events := c.process(request{msg: msg})
+		if e, ok := events[0].(errorEvent); len(events) != 1 || !ok || e.Error == "" {
+			t.Error("expected error event for", msg, "got", events)
+		}
+	}
+	if len(c.locations) != 0 || c.viewport != nil || c.filter.Severity != "Minor" {
+		t.Error("expected subscription to be unchanged")
+	}
+}
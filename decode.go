@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// This file contains the decoders for the formats of upstream sources.
+
+const (
+	// formatCAPXML is a single CAP 1.2 XML document
+	formatCAPXML = "cap-xml"
+)
+
+// errIncomplete is returned by decoders along with the alerts they could decode if parts of the content were unavailable. The
+// alerts are used, but the content is requested again on the next update.
+var errIncomplete = errors.New("incomplete content")
+
+// decoder turns the content of a source into alert messages
+type decoder func(content []byte) ([]alertMessage, error)
+
+// decoders maps source formats to functions creating a decoder for a source. Decoders may keep state between calls, e.g. to cache
+// documents, so each source gets its own.
+var decoders = map[string]func(src Source, log *logrus.Entry) decoder{
+	formatBBKJSON: func(Source, *logrus.Entry) decoder { return decodeBBKJSON },
+	formatCAPXML:  func(Source, *logrus.Entry) decoder { return decodeCAPXML },
+	formatCAPAtom: newAtomDecoder,
+	formatLHP:     func(Source, *logrus.Entry) decoder { return decodeLHP },
+}
+
+// decodeBBKJSON decodes the JSON array of CAP messages served by warnung.bund.de
+func decodeBBKJSON(content []byte) ([]alertMessage, error) {
+	var alerts []alertMessage
+	err := json.Unmarshal(content, &alerts)
+	if err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+// decodeCAPXML decodes a CAP 1.2 XML document
+func decodeCAPXML(content []byte) ([]alertMessage, error) {
+	var doc struct {
+		XMLName xml.Name
+		alertMessage
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(content))
+	dec.CharsetReader = charsetReader
+	err := dec.Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+	if doc.XMLName.Local != "alert" {
+		return nil, fmt.Errorf("unexpected root element <%s>, want <alert>", doc.XMLName.Local)
+	}
+
+	for i := range doc.Info {
+		for j := range doc.Info[i].Area {
+			area := &doc.Info[i].Area[j]
+			for k, poly := range area.Polygon {
+				area.Polygon[k] = swapPolygonOrder(poly)
+			}
+			for k, g := range area.Geocode {
+				if strings.EqualFold(g.ValueName, geocodeExcludePolygon) {
+					area.Geocode[k].Value = swapPolygonOrder(g.Value)
+				}
+			}
+		}
+	}
+	return []alertMessage{doc.alertMessage}, nil
+}
+
+// swapPolygonOrder converts a polygon from the "lat,lon" order of CAP XML to the "lon,lat" order of warnung.bund.de, which is used
+// internally. Malformed points are kept as they are, so parsing the polygon reports them.
+func swapPolygonOrder(poly string) string {
+	points := strings.Fields(poly)
+	for i, p := range points {
+		parts := strings.Split(p, ",")
+		if len(parts) == 2 {
+			points[i] = parts[1] + "," + parts[0]
+		}
+	}
+	return strings.Join(points, " ")
+}
+
+// charsetReader converts XML documents in ISO-8859-1 to UTF-8. Other encodings than these two are not supported.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(label) {
+	case "iso-8859-1", "latin1", "latin-1":
+		return &latin1Reader{r: bufio.NewReader(input)}, nil
+	}
+	return nil, fmt.Errorf("unsupported charset %q", label)
+}
+
+// latin1Reader converts ISO-8859-1 to UTF-8. Every byte of ISO-8859-1 is the code point of the same value.
+type latin1Reader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	for len(l.buf) < len(p) {
+		b, err := l.r.ReadByte()
+		if err != nil {
+			if len(l.buf) > 0 {
+				break
+			}
+			return 0, err
+		}
+		var enc [utf8.UTFMax]byte
+		n := utf8.EncodeRune(enc[:], rune(b))
+		l.buf = append(l.buf, enc[:n]...)
+	}
+	n := copy(p, l.buf)
+	l.buf = l.buf[n:]
+	return n, nil
+}
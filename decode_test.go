@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCAPXML(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/dwd.xml")
+	if err != nil {
+		t.Fatal("can't read test feed:", err)
+	}
+	fromXML, err := decodeCAPXML(content)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	// testdata/dwd.xml holds the same message as testdata/dwd.json, with its polygon in the "lat,lon" order of CAP XML
+	content, err = ioutil.ReadFile("testdata/dwd.json")
+	if err != nil {
+		t.Fatal("can't read test feed:", err)
+	}
+	fromJSON, err := decodeBBKJSON(content)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	if !reflect.DeepEqual(fromXML, fromJSON) {
+		t.Errorf("XML and JSON decoding differ:\n%s\n%s", fromXML, fromJSON)
+	}
+
+	// Annaberg-Buchholz lies in the Erzgebirgskreis
+	a, err := NewAreaFromString(fromXML[0].Info[0].Area[0].Polygon[0])
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if !a.Contains(Coordinate{Latitude: 50.58, Longitude: 13.0}) {
+		t.Error("expected polygon to contain Annaberg-Buchholz, got", a.Bounds())
+	}
+}
+
+func TestDecodeCAPXMLExcludePolygon(t *testing.T) {
+	alerts, err := decodeCAPXML([]byte(`<alert><info><area>
+  <polygon>50.1,7.8 50.1,7.9 50.2,7.9 50.1,7.8</polygon>
+  <geocode><valueName>EXCLUDE_POLYGON</valueName><value>50.12,7.85 50.12,7.86 50.13,7.86 50.12,7.85</value></geocode>
+  <geocode><valueName>WARNCELLID</valueName><value>106412000</value></geocode>
+</area></info></alert>`))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	geocode := alerts[0].Info[0].Area[0].Geocode
+	if geocode[0].Value != "7.85,50.12 7.86,50.12 7.86,50.13 7.85,50.12" || geocode[1].Value != "106412000" {
+		t.Error("expected only the excluded polygon to be swapped, got", geocode)
+	}
+}
+
+func TestSwapPolygonOrder(t *testing.T) {
+	for poly, expected := range map[string]string{
+		"50.1,7.8 50.1,7.9 50.2,7.9 50.1,7.8": "7.8,50.1 7.9,50.1 7.9,50.2 7.8,50.1",
+		" 50.1,7.8  50.2,7.9 ":                "7.8,50.1 7.9,50.2",
+		"50.1,7.8 bogus":                      "7.8,50.1 bogus",
+		"":                                    "",
+	} {
+		if got := swapPolygonOrder(poly); got != expected {
+			t.Errorf("%q: expected %q, got %q", poly, expected, got)
+		}
+	}
+}
+
+func TestDecodeCAPXMLErrors(t *testing.T) {
+	testCases := []string{
+		``,
+		`<feed xmlns="http://www.w3.org/2005/Atom"></feed>`,
+		`<alert xmlns="urn:oasis:names:tc:emergency:cap:1.2"><identifier>foo</identifier>`,
+		`<?xml version="1.0" encoding="KOI8-R"?><alert></alert>`,
+	}
+
+	for _, testCase := range testCases {
+		if _, err := decodeCAPXML([]byte(testCase)); err == nil {
+			t.Errorf("expected error for %q", testCase)
+		}
+	}
+}
+
+func TestDecodeCAPXMLLatin1(t *testing.T) {
+	content := []byte("<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><alert><identifier>foo</identifier><info><headline>Stra\xdfe gesperrt</headline></info></alert>")
+	alerts, err := decodeCAPXML(content)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if h := alerts[0].Info[0].Headline; h != "Straße gesperrt" {
+		t.Errorf("unexpected headline %q", h)
+	}
+}
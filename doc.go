@@ -6,9 +6,86 @@
 //
 //     {
 //         "Latitude": 48.8345,
-//         "Longitude": 8.3819
+//         "Longitude": 8.3819,
+//         "Accuracy": 300
 //     }
 //
+// Accuracy is optional. It is the radius of uncertainty of the location in meters, alerts for areas within that radius are sent as
+// well. It is capped to -maxAccuracy.
+//
+// Clients watching several places, e.g. home and work, can add named locations to the same connection instead:
+//
+//     {"type": "add", "name": "home", "Latitude": 48.8345, "Longitude": 8.3819}
+//     {"type": "remove", "name": "home"}
+//     {"type": "list"}
+//
+// Adding a location with an existing name replaces it, at most -maxLocations locations are allowed. Alerts sent to the client name
+// the locations they affect in the "locations" field, each alert is sent once. "list" is answered with the current locations:
+//
+//     {"type": "locations", "locations": {"home": {"Latitude": 48.8345, "Longitude": 8.3819}}}
+//
+// A plain location without a type is treated as an unnamed location, which is not listed in the "locations" field of alerts.
+//
+// Map clients can subscribe to all alerts intersecting the visible map instead, either by a bounding box or by a polygon:
+//
+//     {"type": "viewport", "bbox": {"Min": {"Latitude": 47.2, "Longitude": 5.8}, "Max": {"Latitude": 55.1, "Longitude": 15.1}}}
+//     {"type": "viewport", "polygon": [{"Latitude": 48.1, "Longitude": 11.5}, {"Latitude": 48.2, "Longitude": 11.7}, ...]}
+//
+// Alerts intersecting the viewport have the "viewport" field set to true. Sending a viewport replaces the previous one, a viewport
+// command without bbox and polygon removes it. Viewports crossing the antimeridian are not supported.
+//
+// To only receive some alerts, add a filter to any command or send it with the filter command:
+//
+//     {"type": "filter", "filter": {"severity": "Moderate", "category": ["Safety", "Fire"]}}
+//
+// severity, urgency and certainty are minimum CAP values, e.g. "Moderate" accepts moderate, severe and extreme alerts. category and
+// event list the accepted values. All given conditions must hold for at least one info block of an alert. The filter applies to all
+// locations and the viewport of the client, a filter command without filter removes it.
+//
+// The messages above are version 1 of the protocol, which is used unless the client negotiates the websocket subprotocol
+// "openwarn.v2". Version 2 wraps all messages in an envelope with a type and a version:
+//
+//     ws = new WebSocket("ws://localhost:8080/coords", ["openwarn.v2"])
+//     ws.send(JSON.stringify({type: "subscribe", version: 2, locations: {home: {Latitude: 48.8345, Longitude: 8.3819}}}))
+//
+// subscribe replaces the whole subscription of the client by the given locations, bbox or polygon and filter. All other commands
+// work as described above. Alerts are sent as
+//
+//     {"type": "alerts", "version": 2, "alerts": [...]}
+//
+// and invalid commands are answered with an error instead of being ignored:
+//
+//     {"type": "error", "version": 2, "error": "unknown location \"work\"", "command": "remove"}
+//
+// Messages that are no valid JSON, locations without Latitude or Longitude, coordinates outside of [-90, 90] latitude and
+// [-180, 180] longitude and binary messages are rejected the same way. A rejected command doesn't change the subscription,
+// including a filter sent along with it. Version 1 clients receive errors without version, which unlike alerts are objects instead
+// of arrays:
+//
+//     {"type": "error", "error": "malformed message: invalid character 'x' looking for beginning of value"}
+//
+// The alerts event is the reply to commands and contains all alerts matching the subscription. When the active alerts change
+// afterwards, only the differences are sent, and only if the alerts matching the subscription of the client changed:
+//
+//     {"type": "added", "version": 2, "alerts": [...]}
+//     {"type": "updated", "version": 2, "alerts": [...], "replaced": [{"feed": "mowas", "identifier": "mow.DE-NW-BN-SE030-20200122-30-000"}]}
+//     {"type": "removed", "version": 2, "alerts": [{"feed": "mowas", "identifier": "...", "reason": "retracted"}]}
+//
+// An alert is updated if its content, matched locations or viewport changed, or if it is a CAP update superseding a delivered alert.
+// The superseded alerts are listed in "replaced" and not reported as removed. The reason of removed alerts is "retracted" for
+// cancelled or superseded alerts, "expired" or "unmatched". Expired alerts are removed as soon as they expire, even if their feed
+// didn't change. Version 1 clients receive all matching alerts if they changed.
+//
+// Events describing alerts carry a sequence number in the "seq" field, which increases with every change of the active alerts,
+// and the "epoch" it belongs to. Sequence numbers restart when the proxy restarts, which changes the epoch. Clients losing their
+// connection can pass the last sequence number and epoch they received as "since" and "epoch" when subscribing again:
+//
+//     {"type": "subscribe", "version": 2, "since": 1234, "epoch": "kx3v9q1c2f0", "locations": {...}}
+//
+// Instead of all alerts, the proxy then replays the events missed in between, including alerts that were issued and cancelled
+// while the client was offline. This requires the subscription to be unchanged. The last -eventLogSize changes are kept for this,
+// if since is older or from another epoch, the alerts event with all matching alerts is sent as usual.
+//
 // The response is a JSON encoded array of alerts. To get an idea of how the response may look, take a look at the source for the
 // alert messages at one of the following URLs:
 //
@@ -30,4 +107,61 @@
 //
 // You will immediately get a list of active alerts for that location, and if a new one comes in, it will be provided to the
 // websocket and the callback function will run again.
+//
+// The feeds listed above are polled by default. To poll other feeds, e.g. a local mirror, either pass them as flags
+//
+//  ./OpenWarn-Proxy -source=mowas=http://localhost:8081/mowas.json -source=dwd=http://localhost:8081/dwd.json
+//
+// or list them in a JSON file passed with -sourceConfig:
+//
+//  {
+//      "sources": [
+//          {"name": "mowas", "url": "https://warnung.bund.de/bbk.mowas/gefahrendurchsagen.json", "pollInterval": "1m"},
+//          {"name": "lhp", "url": "https://warnung.bund.de/bbk.lhp/hochwassermeldungen.json", "enabled": false}
+//      ]
+//  }
+//
+// The format of a source is either "bbk-json" (the default) for the JSON feeds of warnung.bund.de, "cap-xml" for a single CAP 1.2
+// XML document, "cap-atom" for an Atom or RSS feed linking to CAP 1.2 XML documents or "lhp" for the flood reports of the
+// Länderübergreifendes Hochwasserportal. The latter are turned into alerts covering a state or the surroundings of a river gauge.
+// Sources without a pollInterval are polled every -updateDelay. Each alert sent to clients carries the name of its source in the
+// "feed" field.
+//
+// Failing sources are retried with an exponential backoff of up to -maxBackoff. CAP documents of a cap-atom feed failing to fetch
+// are retried the same way on their own, the rest of the feed is still polled as usual and only reports the error. The health of
+// all sources is served as JSON at -statusPath (default "/sources"):
+//
+//  [
+//      {"name": "dwd", "failures": 0, "lastSuccess": "2020-01-22T16:05:31+01:00", "stale": false},
+//      {"name": "lhp", "failures": 12, "lastSuccess": "2020-01-22T14:50:02+01:00", "lastError": "unexpected status 502 Bad Gateway", "stale": true}
+//  ]
+//
+// A source is stale if it could not be fetched for longer than -staleAfter, so its alerts may be outdated.
+//
+// Clients are pinged every -pingInterval. Clients not answering within -pingTimeout, or sending neither messages nor pongs for
+// -readTimeout, are disconnected. The number of connected clients and of closed connections by reason are served as JSON at
+// -clientsPath (default "/clients"):
+//
+//  {"connected": 1042, "disconnects": {"closed": 5121, "ping timeout": 312, "read error": 97}}
+//
+// Messages to a client are queued, at most -sendQueueSize of them. A client taking longer than -writeTimeout to receive a message
+// is disconnected. If the queue of a client is full, the proxy either resyncs the client by dropping all queued messages, including
+// pending replies, and sending an alerts event with all matching alerts instead (-slowClients=resync, the default), or disconnects
+// the client (-slowClients=disconnect), which may then resume its connection.
+//
+// Browsers may only open websockets from the proxy's own host. Allow other websites with -allowOrigin, either by their full origin
+// or by a host pattern:
+//
+//  ./OpenWarn-Proxy -allowOrigin=https://warn.example.org -allowOrigin=*.example.net
+//
+// Websockets from other origins are rejected with 403 Forbidden and logged. The same origins may read -statusPath and -clientsPath
+// via CORS. Clients outside of browsers send no origin and are always accepted.
+//
+// Many alerts describe their areas only by geocodes instead of polygons. To deliver them, pass GeoJSON files with the boundaries of
+// municipalities, districts or DWD warn cells with -boundaries, e.g. the VG250 dataset of the BKG:
+//
+//  ./OpenWarn-Proxy -boundaries=vg250_krs.geojson -boundaries=vg250_gem.geojson -boundaries=dwd_warncells.geojson
+//
+// Features are looked up by their AGS, ARS, RS and WARNCELLID properties. Flood warnings of lhp sources for whole states only carry
+// the AGS of the state, so they require the boundaries of the states, e.g. vg250_lan.geojson.
 package main
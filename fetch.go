@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// This file contains the HTTP side of polling upstream sources.
+
+// errNotModified is returned by fetcher.fetch if the source did not change since the last committed fetch.
+var errNotModified = errors.New("not modified")
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// validators are used to detect whether a source changed between two fetches.
+type validators struct {
+	etag         string
+	lastModified string
+	hash         [sha256.Size]byte
+}
+
+// fetcher requests the content of a single source. It remembers the ETag and Last-Modified headers of the last committed response
+// and sends conditional requests with them. Sources that don't support conditional requests are compared by a hash of the body.
+//
+// A fetcher is not safe for concurrent use, each update loop owns one.
+type fetcher struct {
+	src       Source
+	decode    decoder
+	committed validators
+	pending   validators
+}
+
+func newFetcher(src Source, log *logrus.Entry) *fetcher {
+	return &fetcher{
+		src:    src,
+		decode: decoders[src.Format](src, log),
+	}
+}
+
+// fetch requests the content of the source. It returns errNotModified if the server answered with 304 Not Modified or the body is
+// identical to the last committed one.
+func (f *fetcher) fetch() ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, string(f.src.URL), nil)
+	if err != nil {
+		return nil, err
+	}
+	if f.committed.etag != "" {
+		req.Header.Set("If-None-Match", f.committed.etag)
+	}
+	if f.committed.lastModified != "" {
+		req.Header.Set("If-Modified-Since", f.committed.lastModified)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, errNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f.pending = validators{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		hash:         sha256.Sum256(content),
+	}
+	if f.pending.hash == f.committed.hash {
+		// Same content, but the server doesn't know. Remember its headers anyway in case they changed.
+		f.commit()
+		return nil, errNotModified
+	}
+
+	return content, nil
+}
+
+// commit marks the content returned by the last call to fetch as processed. Until commit is called, subsequent fetches are not
+// conditional on that content, so content that could not be processed is requested again.
+func (f *fetcher) commit() {
+	f.committed = f.pending
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetcherConditionalRequests(t *testing.T) {
+	body := `[]`
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	f := newFetcher(Source{Name: "test", URL: URL(srv.URL), Format: formatBBKJSON}, testLog)
+
+	content, err := f.fetch()
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if string(content) != body {
+		t.Errorf("unexpected content %q", content)
+	}
+
+	// Not committed yet, so the next request is unconditional
+	if _, err := f.fetch(); err != nil {
+		t.Fatal("expected uncommitted content to be fetched again, got", err)
+	}
+
+	f.commit()
+	if _, err := f.fetch(); !errors.Is(err, errNotModified) {
+		t.Error("expected errNotModified after commit, got", err)
+	}
+	if requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+}
+
+func TestFetcherHashFallback(t *testing.T) {
+	body := `[]`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	f := newFetcher(Source{Name: "test", URL: URL(srv.URL), Format: formatBBKJSON}, testLog)
+	if _, err := f.fetch(); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	f.commit()
+
+	if _, err := f.fetch(); !errors.Is(err, errNotModified) {
+		t.Error("expected errNotModified for identical body, got", err)
+	}
+
+	body = `[{}]`
+	if _, err := f.fetch(); err != nil {
+		t.Error("expected changed body to be returned, got", err)
+	}
+}
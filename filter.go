@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This file contains the filters clients use to restrict the alerts they receive.
+
+// The CAP 1.2 values of severity, urgency and certainty, ranked from least to most important. Unknown and unsupported values rank
+// lowest.
+var (
+	severityRanks  = map[string]int{"Unknown": 0, "Minor": 1, "Moderate": 2, "Severe": 3, "Extreme": 4}
+	urgencyRanks   = map[string]int{"Unknown": 0, "Past": 1, "Future": 2, "Expected": 3, "Immediate": 4}
+	certaintyRanks = map[string]int{"Unknown": 0, "Unlikely": 1, "Possible": 2, "Likely": 3, "Very Likely": 3, "Observed": 4}
+)
+
+// alertFilter restricts the alerts sent to a client. Severity, Urgency and Certainty are minimum values, Category and Event list the
+// accepted values. Empty fields accept everything.
+type alertFilter struct {
+	Severity  string   `json:"severity,omitempty"`
+	Urgency   string   `json:"urgency,omitempty"`
+	Certainty string   `json:"certainty,omitempty"`
+	Category  []string `json:"category,omitempty"`
+	Event     []string `json:"event,omitempty"`
+}
+
+// validate returns an error if f uses values unknown to CAP.
+func (f alertFilter) validate() error {
+	for _, v := range []struct {
+		name, value string
+		ranks       map[string]int
+	}{
+		{"severity", f.Severity, severityRanks},
+		{"urgency", f.Urgency, urgencyRanks},
+		{"certainty", f.Certainty, certaintyRanks},
+	} {
+		if _, ok := rank(v.ranks, v.value); v.value != "" && !ok {
+			return fmt.Errorf("unknown %s %q", v.name, v.value)
+		}
+	}
+	return nil
+}
+
+// rank returns the rank of value in ranks, ignoring case. ok is false if value is not ranked.
+func rank(ranks map[string]int, value string) (r int, ok bool) {
+	for k, r := range ranks {
+		if strings.EqualFold(k, value) {
+			return r, true
+		}
+	}
+	return 0, false
+}
+
+// atLeast returns true if value ranks at least as high as min. An empty min accepts everything.
+func atLeast(ranks map[string]int, value, min string) bool {
+	if min == "" {
+		return true
+	}
+	r, _ := rank(ranks, value)
+	m, _ := rank(ranks, min)
+	return r >= m
+}
+
+// oneOf returns true if any of values is in accepted, ignoring case. An empty accepted list accepts everything.
+func oneOf(accepted []string, values ...string) bool {
+	if len(accepted) == 0 {
+		return true
+	}
+	for _, a := range accepted {
+		for _, v := range values {
+			if strings.EqualFold(a, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesInfo returns true if info is accepted by f.
+func (f alertFilter) matchesInfo(info infoItem) bool {
+	return atLeast(severityRanks, info.Severity, f.Severity) &&
+		atLeast(urgencyRanks, info.Urgency, f.Urgency) &&
+		atLeast(certaintyRanks, info.Certainty, f.Certainty) &&
+		oneOf(f.Category, info.Category...) &&
+		oneOf(f.Event, info.Event)
+}
+
+// matches returns true if any info block of m is accepted by f. Messages usually carry one info block per language, which only
+// differ in their texts.
+func (f alertFilter) matches(m alertMessage) bool {
+	for _, info := range m.Info {
+		if f.matchesInfo(info) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAlertFilter(t *testing.T) {
+	frost := alertMessage{Info: []infoItem{{Category: []string{"Met"}, Event: "FROST", Severity: "Minor", Urgency: "Immediate", Certainty: "Observed"}}}
+	fire := alertMessage{Info: []infoItem{{Category: []string{"Safety", "Fire"}, Event: "Gefahrenmitteilung", Severity: "Severe", Urgency: "Expected", Certainty: "Likely"}}}
+	bilingual := alertMessage{Info: []infoItem{
+		{Category: []string{"Met"}, Event: "STURMBÖEN", Severity: "Moderate", Language: "de-DE"},
+		{Category: []string{"Met"}, Event: "STORM", Severity: "Moderate", Language: "en-GB"},
+	}}
+
+	testCases := []struct {
+		filter   string
+		expected []bool // frost, fire, bilingual
+	}{
+		{`{}`, []bool{true, true, true}},
+		{`{"severity": "Moderate"}`, []bool{false, true, true}},
+		{`{"severity": "severe"}`, []bool{false, true, false}},
+		{`{"category": ["Safety", "Fire"]}`, []bool{false, true, false}},
+		{`{"event": ["storm"]}`, []bool{false, false, true}},
+		{`{"urgency": "Immediate"}`, []bool{true, false, false}},
+		{`{"certainty": "Likely", "category": ["Met"]}`, []bool{true, false, false}},
+	}
+	for _, testCase := range testCases {
+		var f alertFilter
+		if err := json.Unmarshal([]byte(testCase.filter), &f); err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		if err := f.validate(); err != nil {
+			t.Fatal(testCase.filter, "unexpected error", err)
+		}
+		for i, m := range []alertMessage{frost, fire, bilingual} {
+			if is := f.matches(m); is != testCase.expected[i] {
+				t.Errorf("%s: expected %t for %s, got %t", testCase.filter, testCase.expected[i], m.Info[0].Event, is)
+			}
+		}
+	}
+}
+
+func TestAlertFilterValidate(t *testing.T) {
+	invalid := []alertFilter{
+		{Severity: "Catastrophic"},
+		{Urgency: "Now"},
+		{Certainty: "Sure"},
+	}
+	for _, f := range invalid {
+		if err := f.validate(); err == nil {
+			t.Error("expected error for", f)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// This file contains the resolution of CAP geocodes into areas using local boundary datasets.
+
+// geocodeKey identifies a region in a boundaries dataset, e.g. {"ags", "05314"}
+type geocodeKey struct {
+	kind, value string
+}
+
+// newGeocodeKey normalizes a CAP geocode or a boundary property. AGS and ARS share one key space, with trailing zeros stripped so
+// that e.g. the ARS "053140000000" of a district matches its AGS "05314000". It returns false if name is not a supported geocode.
+func newGeocodeKey(name, value string) (geocodeKey, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return geocodeKey{}, false
+	}
+	switch strings.ToUpper(name) {
+	case "AGS", "ARS", "RS":
+		return geocodeKey{"ags", strings.TrimRight(value, "0")}, true
+	case "WARNCELLID":
+		return geocodeKey{"warncell", value}, true
+	}
+	return geocodeKey{}, false
+}
+
+// arsToAGS converts a 12 digit ARS of a municipality that is part of an association into its 8 digit AGS. It returns false if ars
+// is not such an ARS.
+func arsToAGS(name, ars string) (string, bool) {
+	if strings.ToUpper(name) != "ARS" || len(ars) != 12 {
+		return "", false
+	}
+	return ars[:5] + ars[9:], true
+}
+
+// boundaries maps geocodes to their areas
+type boundaries struct {
+	areas map[geocodeKey][]Area
+}
+
+func newBoundaries() *boundaries {
+	return &boundaries{areas: make(map[geocodeKey][]Area)}
+}
+
+// resolve returns the areas of a CAP geocode. It returns nil if the geocode is unknown or b is nil.
+func (b *boundaries) resolve(g valuePair) []Area {
+	if b == nil {
+		return nil
+	}
+	key, ok := newGeocodeKey(g.ValueName, g.Value)
+	if !ok {
+		return nil
+	}
+	if areas, ok := b.areas[key]; ok {
+		return areas
+	}
+	if ags, ok := arsToAGS(g.ValueName, g.Value); ok {
+		key, _ = newGeocodeKey("AGS", ags)
+		return b.areas[key]
+	}
+	return nil
+}
+
+type geoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Properties map[string]interface{} `json:"properties"`
+	Geometry   *geoJSONGeometry       `json:"geometry"`
+}
+
+type geoJSONFeatureCollection struct {
+	Features []geoJSONFeature `json:"features"`
+}
+
+// polygons returns the polygons of g as lists of rings. The first ring of each polygon is the outer boundary, the others are holes.
+func (g *geoJSONGeometry) polygons() ([][][]Coordinate, error) {
+	var raw [][][][]float64
+	switch g.Type {
+	case "Polygon":
+		var polygon [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &polygon); err != nil {
+			return nil, err
+		}
+		raw = [][][][]float64{polygon}
+	case "MultiPolygon":
+		if err := json.Unmarshal(g.Coordinates, &raw); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported geometry type %s", g.Type)
+	}
+
+	polygons := make([][][]Coordinate, 0, len(raw))
+	for _, polygon := range raw {
+		rings := make([][]Coordinate, 0, len(polygon))
+		for _, ring := range polygon {
+			coords := make([]Coordinate, 0, len(ring))
+			for _, position := range ring {
+				if len(position) < 2 {
+					return nil, fmt.Errorf("invalid position %v", position)
+				}
+				// GeoJSON positions are longitude first
+				coords = append(coords, Coordinate{Latitude: position[1], Longitude: position[0]})
+			}
+			rings = append(rings, coords)
+		}
+		polygons = append(polygons, rings)
+	}
+	return polygons, nil
+}
+
+// geocodeLengths are the number of digits of full length codes, which are zero padded
+var geocodeLengths = map[string]int{"AGS": 8, "ARS": 12, "RS": 12}
+
+// propertyString returns the GeoJSON property name with value v as string. Codes are sometimes stored as numbers, losing the leading
+// zero of the states 01 to 09. Numeric AGS, ARS and RS are assumed to be full length codes and padded again.
+func propertyString(name string, v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		s := strconv.FormatFloat(v, 'f', -1, 64)
+		if n := geocodeLengths[strings.ToUpper(name)]; len(s) < n {
+			s = strings.Repeat("0", n-len(s)) + s
+		}
+		return s
+	}
+	return ""
+}
+
+// load adds the features of the GeoJSON FeatureCollection in content. Each feature is registered under all of its AGS, ARS, RS and
+// WARNCELLID properties. It returns the number of features that were registered.
+func (b *boundaries) load(content []byte) (int, error) {
+	var fc geoJSONFeatureCollection
+	if err := json.Unmarshal(content, &fc); err != nil {
+		return 0, err
+	}
+
+	registered := 0
+	for i, feature := range fc.Features {
+		// AGS and ARS of a feature often normalize to the same key
+		keys := make(map[geocodeKey]bool)
+		for name, value := range feature.Properties {
+			if key, ok := newGeocodeKey(name, propertyString(name, value)); ok {
+				keys[key] = true
+			}
+		}
+		if len(keys) == 0 || feature.Geometry == nil {
+			continue
+		}
+
+		polygons, err := feature.Geometry.polygons()
+		if err != nil {
+			return registered, fmt.Errorf("feature %d: %w", i, err)
+		}
+		var areas []Area
+		for _, rings := range polygons {
+			areas = append(areas, newAreaFromRings(rings))
+		}
+
+		for key := range keys {
+			b.areas[key] = append(b.areas[key], areas...)
+		}
+		registered++
+	}
+
+	return registered, nil
+}
+
+// loadBoundaries reads the GeoJSON files at paths into a boundaries index.
+func loadBoundaries(paths []string) (*boundaries, int, error) {
+	b := newBoundaries()
+	total := 0
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, total, err
+		}
+		n, err := b.load(content)
+		if err != nil {
+			return nil, total, fmt.Errorf("loading boundaries %s: %w", path, err)
+		}
+		total += n
+	}
+	return b, total, nil
+}
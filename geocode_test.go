@@ -0,0 +1,85 @@
+package main
+
+import "testing"
+
+func TestBoundariesResolve(t *testing.T) {
+	b, n, err := loadBoundaries([]string{"testdata/boundaries.geojson"})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 features with geocodes, got %d", n)
+	}
+
+	testCases := []struct {
+		geocode valuePair
+		areas   int
+	}{
+		{valuePair{"AGS", "05314000"}, 1},
+		{valuePair{"ARS", "053140000000"}, 1},
+		{valuePair{"ags", "05314"}, 1},
+		{valuePair{"WARNCELLID", "114521000"}, 2},
+		{valuePair{"ARS", "097800000000"}, 1},
+		{valuePair{"AGS", "05315000"}, 0},
+		{valuePair{"AreaId", "0"}, 0},
+	}
+	for _, testCase := range testCases {
+		if areas := b.resolve(testCase.geocode); len(areas) != testCase.areas {
+			t.Errorf("%v: expected %d areas, got %d", testCase.geocode, testCase.areas, len(areas))
+		}
+	}
+
+	var nilBoundaries *boundaries
+	if areas := nilBoundaries.resolve(valuePair{"AGS", "05314000"}); areas != nil {
+		t.Error("expected nil boundaries to resolve nothing, got", areas)
+	}
+}
+
+func TestGeocodeOnlyAlerts(t *testing.T) {
+	b, _, err := loadBoundaries([]string{"testdata/boundaries.geojson"})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	alert := alertMessage{
+		Identifier: "geocoded",
+		Info: []infoItem{{Area: []areaDescription{
+			{Description: "Bonn", Geocode: []valuePair{{"ARS", "053140000000"}}},
+			{Description: "Kreis", Geocode: []valuePair{{"WARNCELLID", "114521000"}}},
+			{Description: "Kreis mit Loch", Geocode: []valuePair{{"AGS", "09780000"}}},
+		}}},
+	}
+	s := newSnapshot().withSource("test", newSourceSnapshot("test", []alertMessage{alert}, b, testLog))
+
+	testCases := []testCase{
+		{true, Coordinate{50.7, 7.1}},    // Bonn
+		{true, Coordinate{50.05, 14.05}}, // Exclave
+		{true, Coordinate{47.5, 10.1}},   // District around the hole
+		{false, Coordinate{47.65, 10.3}}, // Hole
+		{false, Coordinate{52.5, 13.4}},  // Berlin
+	}
+	for _, testCase := range testCases {
+		is := len(s.matchingAlerts(testCase.c, 0, alert.Sent)) == 1
+		if is != testCase.expected {
+			t.Error("expected:", testCase.c, "=", testCase.expected, "got:", is)
+		}
+	}
+}
+
+func TestBoundariesNumericCodes(t *testing.T) {
+	b := newBoundaries()
+	n, err := b.load([]byte(`{"type": "FeatureCollection", "features": [
+		{"properties": {"AGS": 5314000}, "geometry": {"type": "Polygon", "coordinates": [[[7, 50], [8, 50], [8, 51], [7, 50]]]}},
+		{"properties": {"ARS": 97800000000}, "geometry": {"type": "Polygon", "coordinates": [[[10, 47], [11, 47], [11, 48], [10, 47]]]}},
+		{"properties": {"WARNCELLID": 114521000}, "geometry": {"type": "Polygon", "coordinates": [[[12, 50], [13, 50], [13, 51], [12, 50]]]}}
+	]}`))
+	if err != nil || n != 3 {
+		t.Fatal("unexpected result", n, err)
+	}
+
+	for _, geocode := range []valuePair{{"AGS", "05314000"}, {"ARS", "097800000000"}, {"AGS", "09780000"}, {"WARNCELLID", "114521000"}} {
+		if areas := b.resolve(geocode); len(areas) != 1 {
+			t.Errorf("%v: expected 1 area, got %d", geocode, len(areas))
+		}
+	}
+}
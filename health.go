@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// This file contains the health tracking of upstream sources.
+
+// sourceHealth describes how well fetching a source worked recently.
+type sourceHealth struct {
+	Failures    int       // Consecutive failed fetches
+	LastAttempt time.Time // Time of the last fetch, successful or not
+	LastSuccess time.Time // Time of the last successful fetch, zero if there was none yet
+	LastError   string    // Error of the last failed or incomplete fetch, empty after a complete success
+}
+
+// succeeded records a successful fetch at t.
+func (h *sourceHealth) succeeded(t time.Time) {
+	h.Failures = 0
+	h.LastAttempt = t
+	h.LastSuccess = t
+	h.LastError = ""
+}
+
+// incomplete records a fetch at t that only got parts of the content. It counts as a success, so the source is neither backed off
+// nor stale, but err is reported.
+func (h *sourceHealth) incomplete(t time.Time, err error) {
+	h.succeeded(t)
+	h.LastError = err.Error()
+}
+
+// failed records a failed fetch at t.
+func (h *sourceHealth) failed(t time.Time, err error) {
+	h.Failures++
+	h.LastAttempt = t
+	h.LastError = err.Error()
+}
+
+// stale returns true if the data of the source can't be trusted to be current at now, because the last successful fetch is older
+// than staleAfter or there has been none yet despite failed attempts.
+func (h sourceHealth) stale(now time.Time, staleAfter time.Duration) bool {
+	if h.LastSuccess.IsZero() {
+		return h.Failures > 0
+	}
+	return now.Sub(h.LastSuccess) > staleAfter
+}
+
+// backoff returns the delay before the next fetch. After a success, this is interval. After consecutive failures, the delay is
+// doubled per failure up to maxDelay, or interval if that is longer, and jittered by up to half of it so failing sources are not
+// retried in lock step.
+func (h sourceHealth) backoff(interval, maxDelay time.Duration) time.Duration {
+	if h.Failures == 0 {
+		return interval
+	}
+	if maxDelay < interval {
+		maxDelay = interval
+	}
+
+	delay := interval
+	for i := 1; i < h.Failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// sourceStatus is the health of a source as reported to clients.
+type sourceStatus struct {
+	Name        SourceName `json:"name"`
+	Failures    int        `json:"failures"`
+	LastSuccess *time.Time `json:"lastSuccess"` // null if there was no successful fetch yet
+	LastError   string     `json:"lastError,omitempty"`
+	Stale       bool       `json:"stale"`
+}
+
+func newSourceStatus(name SourceName, h sourceHealth, now time.Time, staleAfter time.Duration) sourceStatus {
+	s := sourceStatus{
+		Name:      name,
+		Failures:  h.Failures,
+		LastError: h.LastError,
+		Stale:     h.stale(now, staleAfter),
+	}
+	if !h.LastSuccess.IsZero() {
+		t := h.LastSuccess
+		s.LastSuccess = &t
+	}
+	return s
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	var h sourceHealth
+	if d := h.backoff(time.Minute, time.Hour); d != time.Minute {
+		t.Errorf("expected interval without failures, got %s", d)
+	}
+
+	testCases := []struct {
+		failures int
+		max      time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{10, time.Hour},
+	}
+	for _, testCase := range testCases {
+		h.Failures = testCase.failures
+		for i := 0; i < 100; i++ {
+			d := h.backoff(time.Minute, time.Hour)
+			if d < testCase.max/2 || d > testCase.max {
+				t.Errorf("failures=%d: delay %s not within [%s, %s]", testCase.failures, d, testCase.max/2, testCase.max)
+			}
+		}
+	}
+}
+
+func TestBackoffLongInterval(t *testing.T) {
+	// maxDelay doesn't cap the delay below the poll interval, which would poll failing sources more often than healthy ones
+	for _, failures := range []int{1, 5} {
+		h := sourceHealth{Failures: failures}
+		for i := 0; i < 100; i++ {
+			if d := h.backoff(time.Hour, 30*time.Minute); d < 30*time.Minute || d > time.Hour {
+				t.Errorf("failures=%d: delay %s not within [30m, 1h]", failures, d)
+			}
+		}
+	}
+}
+
+func TestStale(t *testing.T) {
+	now := time.Now()
+	var h sourceHealth
+
+	if h.stale(now, time.Hour) {
+		t.Error("source without any attempts should not be stale")
+	}
+	h.failed(now, errors.New("boom"))
+	if !h.stale(now, time.Hour) {
+		t.Error("source without any successful fetch should be stale after a failure")
+	}
+	h.succeeded(now.Add(-30 * time.Minute))
+	if h.stale(now, time.Hour) {
+		t.Error("source should not be stale within staleAfter")
+	}
+	h.failed(now, errors.New("boom"))
+	if h.stale(now, time.Hour) {
+		t.Error("a single failure should not make a source stale within staleAfter")
+	}
+	if !h.stale(now.Add(time.Hour), time.Hour) {
+		t.Error("source should be stale after staleAfter without success")
+	}
+}
+
+func TestIncomplete(t *testing.T) {
+	now := time.Now()
+	var h sourceHealth
+	h.failed(now, errors.New("boom"))
+	h.incomplete(now, errors.New("failed to fetch 1 of 3 entries"))
+	if h.Failures != 0 || h.backoff(time.Minute, time.Hour) != time.Minute || h.stale(now, time.Hour) {
+		t.Error("expected incomplete fetch to count as success, got", h)
+	}
+	if h.LastError == "" {
+		t.Error("expected error of incomplete fetch to be reported")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import "math"
+
+// This file contains the spatial index used to find the alerts affecting a coordinate without checking every area.
+
+const (
+	// gridCellSize is the edge length of grid cells in degrees
+	gridCellSize = 0.25
+	// gridMaxCells is the maximum number of cells a shape is registered in. Larger shapes are checked on every query.
+	gridMaxCells = 4096
+)
+
+type gridCell struct {
+	lat, lon int
+}
+
+func cellOf(c Coordinate) gridCell {
+	return gridCell{
+		lat: int(math.Floor(c.Latitude / gridCellSize)),
+		lon: int(math.Floor(c.Longitude / gridCellSize)),
+	}
+}
+
+type gridEntry struct {
+	id     MessageID
+	shape  Shape
+	bounds BoundingBox
+}
+
+// gridIndex is a spatial index of the shapes of alerts. Every shape is registered in all grid cells its bounding box overlaps, so a
+// lookup only needs to check the shapes of a single cell. A gridIndex is immutable once built.
+type gridIndex struct {
+	entries []gridEntry
+	cells   map[gridCell][]int
+	// large holds the entries that overlap more than gridMaxCells cells
+	large []int
+}
+
+// newGridIndex builds an index of areas.
+func newGridIndex(areas map[MessageID][]Shape) *gridIndex {
+	g := &gridIndex{
+		cells: make(map[gridCell][]int),
+	}
+
+	for id, shapes := range areas {
+		for _, shape := range shapes {
+			b := shape.Bounds()
+			if b.Empty() {
+				continue
+			}
+			i := len(g.entries)
+			g.entries = append(g.entries, gridEntry{id: id, shape: shape, bounds: b})
+
+			lo, hi := cellOf(b.Min), cellOf(b.Max)
+			if (hi.lat-lo.lat+1)*(hi.lon-lo.lon+1) > gridMaxCells {
+				g.large = append(g.large, i)
+				continue
+			}
+			for lat := lo.lat; lat <= hi.lat; lat++ {
+				for lon := lo.lon; lon <= hi.lon; lon++ {
+					cell := gridCell{lat, lon}
+					g.cells[cell] = append(g.cells[cell], i)
+				}
+			}
+		}
+	}
+
+	return g
+}
+
+// near returns the IDs of all alerts that have a shape within radius km of c. Each ID is returned once.
+func (g *gridIndex) near(c Coordinate, radius float64) []MessageID {
+	return g.query(Circle{Center: c, Radius: radius}.Bounds(), func(s Shape) bool {
+		if radius <= 0 {
+			return s.Contains(c)
+		}
+		return s.Distance(c) <= radius
+	})
+}
+
+// intersecting returns the IDs of all alerts that have a shape intersecting a. Each ID is returned once.
+func (g *gridIndex) intersecting(a Area) []MessageID {
+	return g.query(a.Bounds(), func(s Shape) bool {
+		return s.Intersects(a)
+	})
+}
+
+// query returns the IDs of all alerts that have a shape overlapping bounds and matching match. Each ID is returned once.
+func (g *gridIndex) query(bounds BoundingBox, match func(s Shape) bool) []MessageID {
+	var ids []MessageID
+	if bounds.Empty() {
+		return ids
+	}
+	seen := make(map[MessageID]bool)
+
+	check := func(i int) {
+		e := g.entries[i]
+		if seen[e.id] || !e.bounds.Intersects(bounds) || !match(e.shape) {
+			return
+		}
+		seen[e.id] = true
+		ids = append(ids, e.id)
+	}
+
+	lo, hi := cellOf(bounds.Min), cellOf(bounds.Max)
+	if (hi.lat-lo.lat+1)*(hi.lon-lo.lon+1) > gridMaxCells {
+		// Large queries, e.g. a map zoomed out to a whole continent, are faster by checking every entry
+		for i := range g.entries {
+			check(i)
+		}
+		return ids
+	}
+
+	for lat := lo.lat; lat <= hi.lat; lat++ {
+		for lon := lo.lon; lon <= hi.lon; lon++ {
+			for _, i := range g.cells[gridCell{lat, lon}] {
+				check(i)
+			}
+		}
+	}
+	for _, i := range g.large {
+		check(i)
+	}
+
+	return ids
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// randomAreas returns n alerts with random polygons and circles spread over Germany, plus one alert covering all of it.
+func randomAreas(r *rand.Rand, n int) map[MessageID][]Shape {
+	areas := make(map[MessageID][]Shape)
+	for i := 0; i < n; i++ {
+		id := MessageID(rune('a'+i%26)) + MessageID(rune('a'+i/26%26)) + MessageID(rune('a'+i/676))
+		center := Coordinate{47 + r.Float64()*8, 6 + r.Float64()*9}
+		if i%5 == 0 {
+			areas[id] = []Shape{Circle{Center: center, Radius: r.Float64() * 50}}
+			continue
+		}
+		areas[id] = []Shape{randomPolygon(r, center)}
+	}
+	areas["germany"] = []Shape{Circle{Center: Coordinate{51, 10.5}, Radius: 600}}
+	return areas
+}
+
+// linearContaining returns the IDs of all alerts that have a shape containing c by checking all of them.
+func linearContaining(areas map[MessageID][]Shape, c Coordinate) []MessageID {
+	var ids []MessageID
+	for id, shapes := range areas {
+		for _, shape := range shapes {
+			if shape.Contains(c) {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	return ids
+}
+
+func sortedIDs(ids []MessageID) []MessageID {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func TestGridIndexMatchesLinearScan(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	areas := randomAreas(r, 500)
+	g := newGridIndex(areas)
+
+	for i := 0; i < 2000; i++ {
+		c := Coordinate{46 + r.Float64()*10, 5 + r.Float64()*11}
+		want := sortedIDs(linearContaining(areas, c))
+		have := sortedIDs(g.near(c, 0))
+		if len(want) != len(have) {
+			t.Fatalf("%s: expected %v, got %v", c, want, have)
+		}
+		for j := range want {
+			if want[j] != have[j] {
+				t.Fatalf("%s: expected %v, got %v", c, want, have)
+			}
+		}
+	}
+}
+
+func TestGridIndexLargeShapes(t *testing.T) {
+	areas := map[MessageID][]Shape{
+		"world": {Circle{Center: Coordinate{0, 0}, Radius: 20000}},
+		"empty": {Area{}},
+	}
+	g := newGridIndex(areas)
+	if len(g.large) != 1 {
+		t.Error("expected one large entry, got", g.large)
+	}
+	if ids := g.near(Coordinate{51, 10}, 0); len(ids) != 1 || ids[0] != "world" {
+		t.Error("expected world to match, got", ids)
+	}
+}
+
+func TestCircleBounds(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		c := Circle{Center: Coordinate{r.Float64()*160 - 80, r.Float64()*300 - 150}, Radius: r.Float64() * 500}
+		b := c.Bounds()
+		for j := 0; j < 100; j++ {
+			p := Coordinate{c.Center.Latitude + r.Float64()*20 - 10, c.Center.Longitude + r.Float64()*20 - 10}
+			if c.Contains(p) && !b.Contains(p) {
+				t.Fatalf("%s contains %s, but its bounds %s don't", c, p, b)
+			}
+		}
+	}
+}
+
+func benchmarkContaining(b *testing.B, n int, containing func(areas map[MessageID][]Shape, g *gridIndex, c Coordinate) []MessageID) {
+	r := rand.New(rand.NewSource(1))
+	areas := randomAreas(r, n)
+	g := newGridIndex(areas)
+	coords := make([]Coordinate, 1000)
+	for i := range coords {
+		coords[i] = Coordinate{47 + r.Float64()*8, 6 + r.Float64()*9}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		containing(areas, g, coords[i%len(coords)])
+	}
+}
+
+func linear(areas map[MessageID][]Shape, _ *gridIndex, c Coordinate) []MessageID {
+	return linearContaining(areas, c)
+}
+
+func indexed(_ map[MessageID][]Shape, g *gridIndex, c Coordinate) []MessageID {
+	return g.near(c, 0)
+}
+
+func BenchmarkLinear100(b *testing.B)   { benchmarkContaining(b, 100, linear) }
+func BenchmarkLinear1000(b *testing.B)  { benchmarkContaining(b, 1000, linear) }
+func BenchmarkIndexed100(b *testing.B)  { benchmarkContaining(b, 100, indexed) }
+func BenchmarkIndexed1000(b *testing.B) { benchmarkContaining(b, 1000, indexed) }
+
+func BenchmarkBuildIndex1000(b *testing.B) {
+	areas := randomAreas(rand.New(rand.NewSource(1)), 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		newGridIndex(areas)
+	}
+}
+
+func TestGridIndexNear(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	areas := randomAreas(r, 200)
+	delete(areas, "germany")
+	g := newGridIndex(areas)
+
+	for i := 0; i < 200; i++ {
+		c := Coordinate{46 + r.Float64()*10, 5 + r.Float64()*11}
+		radius := r.Float64() * 20
+
+		var want []MessageID
+		for id, shapes := range areas {
+			for _, shape := range shapes {
+				if shape.Distance(c) <= radius {
+					want = append(want, id)
+					break
+				}
+			}
+		}
+		want = sortedIDs(want)
+		have := sortedIDs(g.near(c, radius))
+		if len(want) != len(have) {
+			t.Fatalf("%s, %fkm: expected %v, got %v", c, radius, want, have)
+		}
+		for j := range want {
+			if want[j] != have[j] {
+				t.Fatalf("%s, %fkm: expected %v, got %v", c, radius, want, have)
+			}
+		}
+	}
+}
+
+func TestGridIndexIntersecting(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	areas := randomAreas(r, 200)
+	g := newGridIndex(areas)
+
+	for i := 0; i < 100; i++ {
+		min := Coordinate{46 + r.Float64()*10, 5 + r.Float64()*11}
+		size := r.Float64() * 2
+		if i%10 == 0 {
+			// Large enough to check all entries
+			size = 30
+		}
+		viewport := BoundingBox{Min: min, Max: Coordinate{min.Latitude + size, min.Longitude + size}}.Area()
+
+		var want []MessageID
+		for id, shapes := range areas {
+			for _, shape := range shapes {
+				if shape.Intersects(viewport) {
+					want = append(want, id)
+					break
+				}
+			}
+		}
+		want = sortedIDs(want)
+		have := sortedIDs(g.intersecting(viewport))
+		if len(want) != len(have) {
+			t.Fatalf("%s: expected %v, got %v", viewport.Bounds(), want, have)
+		}
+		for j := range want {
+			if want[j] != have[j] {
+				t.Fatalf("%s: expected %v, got %v", viewport.Bounds(), want, have)
+			}
+		}
+	}
+}
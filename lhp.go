@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// This file contains the decoder for the flood reports of the Länderübergreifendes Hochwasserportal (LHP). Unlike the other feeds
+// of warnung.bund.de, these are not CAP messages but the flood warning level of each state and its river gauges.
+
+const (
+	// formatLHP is the flood report of the LHP
+	formatLHP = "lhp"
+
+	// lhpGaugeRadius is the radius around a river gauge in km that alerts for the gauge cover
+	lhpGaugeRadius = 10.0
+)
+
+type lhpGauge struct {
+	ID    string    `json:"id"`
+	Name  string    `json:"name"`
+	River string    `json:"river"`
+	Lat   float64   `json:"lat"`
+	Lon   float64   `json:"lon"`
+	Level int       `json:"level"` // Warning level from 0 (none) to 4
+	Value float64   `json:"value"` // Water level
+	Unit  string    `json:"unit"`
+	Time  time.Time `json:"time"`
+	URL   URL       `json:"url"`
+}
+
+type lhpState struct {
+	ID         string     `json:"id"`
+	AGS        string     `json:"ags"` // Official key of the state
+	Name       string     `json:"name"`
+	Level      int        `json:"level"` // Highest warning level in the state from 0 (none) to 4
+	Text       string     `json:"text"`
+	URL        URL        `json:"url"`
+	LastUpdate time.Time  `json:"lastUpdate"`
+	Gauges     []lhpGauge `json:"gauges"`
+}
+
+type lhpReport struct {
+	LastUpdate time.Time  `json:"lastUpdate"`
+	States     []lhpState `json:"states"`
+}
+
+// validate returns an error if r lacks the fields needed to create alerts. An empty list of states is valid, it means there are no
+// flood warnings.
+func (r lhpReport) validate() error {
+	if r.States == nil {
+		return errors.New("missing states")
+	}
+	for i, state := range r.States {
+		if state.ID == "" || state.AGS == "" {
+			return fmt.Errorf("state %d lacks id or ags", i)
+		}
+		for j, gauge := range state.Gauges {
+			if gauge.ID == "" {
+				return fmt.Errorf("gauge %d of state %s lacks id", j, state.ID)
+			}
+			c := Coordinate{Latitude: gauge.Lat, Longitude: gauge.Lon}
+			if err := c.validate(); err != nil || c == (Coordinate{}) {
+				return fmt.Errorf("gauge %s lacks valid coordinates", gauge.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// lhpSeverity maps LHP warning levels to CAP severities
+func lhpSeverity(level int) string {
+	switch {
+	case level <= 1:
+		return "Minor"
+	case level == 2:
+		return "Moderate"
+	case level == 3:
+		return "Severe"
+	default:
+		return "Extreme"
+	}
+}
+
+func lhpInfo(severity, headline, description string, web URL, area areaDescription) infoItem {
+	return infoItem{
+		Language:     "de-DE",
+		Category:     []string{"Met"},
+		Event:        "Hochwasser",
+		ResponseType: []string{"Monitor"},
+		Urgency:      "Immediate",
+		Severity:     severity,
+		Certainty:    "Observed",
+		SenderName:   "Länderübergreifendes Hochwasserportal",
+		Headline:     headline,
+		Description:  description,
+		URL:          web,
+		Area:         []areaDescription{area},
+	}
+}
+
+// decodeLHP decodes the LHP flood report. Every state with a warning level yields an alert covering the state by its geocode, and
+// every gauge with a warning level an alert covering the area around the gauge. If the feed turns out to serve CAP messages like the
+// other feeds, they are decoded as such.
+func decodeLHP(content []byte) ([]alertMessage, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("[")) {
+		return decodeBBKJSON(content)
+	}
+
+	var report lhpReport
+	err := json.Unmarshal(content, &report)
+	if err != nil {
+		return nil, err
+	}
+	if err := report.validate(); err != nil {
+		// Don't report an empty feed if its layout changed, so the source shows up as failing
+		return nil, fmt.Errorf("unrecognized LHP report: %w", err)
+	}
+
+	var alerts []alertMessage
+	for _, state := range report.States {
+		if state.Level > 0 {
+			area := areaDescription{
+				Description: state.Name,
+				Polygon:     []string{},
+				Geocode:     []valuePair{{ValueName: "AGS", Value: state.AGS}},
+			}
+			alerts = append(alerts, alertMessage{
+				Identifier: MessageID("LHP-" + state.ID),
+				Sender:     "LHP",
+				Sent:       state.LastUpdate,
+				Status:     "Actual",
+				MsgType:    "Alert",
+				Scope:      "Public",
+				Info: []infoItem{lhpInfo(lhpSeverity(state.Level),
+					fmt.Sprintf("Hochwasserwarnung %s: Warnstufe %d", state.Name, state.Level), state.Text, state.URL, area)},
+			})
+		}
+
+		for _, gauge := range state.Gauges {
+			if gauge.Level <= 0 {
+				continue
+			}
+			web := gauge.URL
+			if web == "" {
+				web = state.URL
+			}
+			area := areaDescription{
+				Description: fmt.Sprintf("Pegel %s (%s)", gauge.Name, gauge.River),
+				Polygon:     []string{},
+				Circle:      []string{fmt.Sprintf("%g,%g %g", gauge.Lat, gauge.Lon, lhpGaugeRadius)},
+				Geocode:     []valuePair{},
+			}
+			alerts = append(alerts, alertMessage{
+				Identifier: MessageID("LHP-" + gauge.ID),
+				Sender:     "LHP",
+				Sent:       gauge.Time,
+				Status:     "Actual",
+				MsgType:    "Alert",
+				Scope:      "Public",
+				Info: []infoItem{lhpInfo(lhpSeverity(gauge.Level),
+					fmt.Sprintf("Hochwasser %s am Pegel %s: Meldestufe %d", gauge.River, gauge.Name, gauge.Level),
+					fmt.Sprintf("Der Wasserstand am Pegel %s (%s) beträgt %g %s.", gauge.Name, gauge.River, gauge.Value, gauge.Unit),
+					web, area)},
+			})
+		}
+	}
+
+	return alerts, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// testdata/lhp.json is a sample of the LHP report format with made up gauge readings, reports recorded from the real feed are kept
+// in testdata/recorded. decodeLHP rejects reports that don't match the format instead of returning no alerts.
+
+func TestDecodeLHP(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/lhp.json")
+	if err != nil {
+		t.Fatal("can't read test feed:", err)
+	}
+
+	alerts, err := decodeLHP(content)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	byID := make(map[MessageID]alertMessage)
+	for _, alert := range alerts {
+		byID[alert.Identifier] = alert
+	}
+	if len(byID) != 4 {
+		t.Fatal("expected alerts for 2 states and 2 gauges, got", alerts)
+	}
+	if _, ok := byID["LHP-HE"]; ok {
+		t.Error("unexpected alert for state without warning level")
+	}
+	if _, ok := byID["LHP-BY-11502009"]; ok {
+		t.Error("unexpected alert for gauge without warning level")
+	}
+
+	state := byID["LHP-SN"]
+	if g := state.Info[0].Area[0].Geocode; len(g) != 1 || g[0].Value != "14" {
+		t.Error("unexpected geocode for state alert", g)
+	}
+
+	gauge := byID["LHP-BY-11402001"]
+	if gauge.Info[0].Severity != "Moderate" {
+		t.Error("unexpected severity", gauge.Info[0].Severity)
+	}
+	a, err := NewCircleFromString(gauge.Info[0].Area[0].Circle[0])
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if a.Radius != lhpGaugeRadius {
+		t.Error("unexpected radius", a.Radius)
+	}
+
+	testCases := []testCase{
+		{true, Coordinate{47.7264, 10.3225}}, // Gauge
+		{true, Coordinate{47.77, 10.32}},     // ~5km north
+		{false, Coordinate{47.9, 10.32}},     // ~20km north
+	}
+	for _, testCase := range testCases {
+		if is := a.Contains(testCase.c); is != testCase.expected {
+			t.Error("expected:", testCase.c, "=", testCase.expected, "got:", is)
+		}
+	}
+}
+
+func TestDecodeLHPCAP(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/mowas.json")
+	if err != nil {
+		t.Fatal("can't read test feed:", err)
+	}
+	alerts, err := decodeLHP(content)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if len(alerts) != 2 {
+		t.Error("expected CAP messages to be decoded, got", alerts)
+	}
+}
+
+func TestDecodeLHPUnrecognized(t *testing.T) {
+	testCases := []string{
+		`{}`,
+		`{"lastUpdate": "2020-01-22T15:00:00+01:00"}`,
+		`{"states": null}`,
+		`{"states": [{"name": "Bayern", "level": 2}]}`,
+		`{"states": [{"id": "BY", "ags": "09", "gauges": [{"name": "Kempten", "level": 2}]}]}`,
+		`{"states": [{"id": "BY", "ags": "09", "gauges": [{"id": "BY-1", "lat": 147.7, "lon": 10.3}]}]}`,
+		`{"states": {"BY": {}}}`,
+		`"states"`,
+	}
+	for _, testCase := range testCases {
+		if _, err := decodeLHP([]byte(testCase)); err == nil {
+			t.Errorf("expected error for %s", testCase)
+		}
+	}
+
+	alerts, err := decodeLHP([]byte(`{"states": []}`))
+	if err != nil || len(alerts) != 0 {
+		t.Error("expected no alerts without states, got", alerts, err)
+	}
+}
+
+// TestDecodeLHPRecorded decodes the reports recorded from the real feed, see testdata/recorded/README.md.
+func TestDecodeLHPRecorded(t *testing.T) {
+	recorded, err := filepath.Glob("testdata/recorded/lhp-*.json")
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	for _, path := range recorded {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal("can't read test feed:", err)
+		}
+		if _, err := decodeLHP(content); err != nil {
+			t.Error(path, "unexpected error", err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// This file contains the checks of the origin of browser requests.
+//
+// Browsers send the Origin header with websocket handshakes and cross-origin requests. Without checking it, any website could open a
+// websocket to the proxy from the browsers of its visitors. Requests without an Origin header don't come from a browser and are
+// always allowed, as are requests from the host serving the proxy itself.
+
+// originPolicy lists the origins allowed to use the proxy. Patterns containing "://" match the whole origin, e.g.
+// "https://warn.example.org", others only match its host, e.g. "*.example.org". Patterns use the syntax of path.Match and
+// ignore case. "*" allows all origins.
+type originPolicy []string
+
+// validate returns an error if p contains malformed patterns.
+func (p originPolicy) validate() error {
+	for _, pattern := range p {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid origin pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// allows returns true if r may use the proxy. err describes why it may not.
+func (p originPolicy) allows(r *http.Request) (bool, error) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true, nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false, fmt.Errorf("malformed origin %q: %w", origin, err)
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true, nil
+	}
+	for _, pattern := range p {
+		target := u.Host
+		if strings.Contains(pattern, "://") {
+			target = origin
+		}
+		// Patterns are validated on startup
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(target)); ok {
+			return true, nil
+		}
+	}
+	return false, fmt.Errorf("origin %q not allowed", origin)
+}
+
+// cors adds the CORS headers allowing the origins of p to read the responses of h. Requests from other origins are served without
+// them, so browsers don't pass the responses to the requesting website.
+func (p originPolicy) cors(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if ok, err := p.allows(r); origin != "" && ok {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		} else if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"component": "cors",
+				"remote":    r.RemoteAddr,
+				"path":      r.URL.Path,
+			}).Debug("Not allowing cross-origin request:", err)
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			h(w, r)
+		case http.MethodOptions:
+			// Preflight request
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+			w.Header().Set("Access-Control-Max-Age", "86400")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
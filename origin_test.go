@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nhooyr.io/websocket"
+)
+
+func TestOriginPolicyAllows(t *testing.T) {
+	p := originPolicy{"https://warn.example.org", "*.example.net"}
+	for origin, allowed := range map[string]bool{
+		"":                         true, // No browser
+		"http://proxy.local:8080":  true, // Own host
+		"https://warn.example.org": true,
+		"https://WARN.example.org": true,
+		"http://warn.example.org":  false,
+		"https://example.org":      false,
+		"https://a.example.net":    true,
+		"http://a.example.net":     true,
+		"https://example.net":      false,
+		"https://evil.example":     false,
+		"%zz":                      false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://proxy.local:8080/coords", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if ok, err := p.allows(r); ok != allowed || ok != (err == nil) {
+			t.Errorf("%q: expected %v, got %v (%v)", origin, allowed, ok, err)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://proxy.local/coords", nil)
+	r.Header.Set("Origin", "https://evil.example")
+	if ok, err := (originPolicy{"*"}).allows(r); !ok {
+		t.Error("expected * to allow all origins, got", err)
+	}
+	if err := (originPolicy{"[a-"}).validate(); err == nil {
+		t.Error("expected error for malformed pattern")
+	}
+}
+
+func TestCORS(t *testing.T) {
+	h := originPolicy{"*.example.org"}.cors(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	})
+
+	for origin, allowed := range map[string]bool{"https://warn.example.org": true, "https://evil.example": false} {
+		r := httptest.NewRequest(http.MethodGet, "http://proxy.local/sources", nil)
+		r.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != "{}" {
+			t.Error(origin, "unexpected response", w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); (got == origin) != allowed || (got == "") == allowed {
+			t.Errorf("%s: unexpected Access-Control-Allow-Origin %q", origin, got)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodOptions, "http://proxy.local/sources", nil)
+	r.Header.Set("Origin", "https://warn.example.org")
+	w := httptest.NewRecorder()
+	h(w, r)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") == "" || w.Body.Len() != 0 {
+		t.Error("unexpected preflight response", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodPost, "http://proxy.local/sources", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Error("expected POST to be rejected, got", w.Code)
+	}
+}
+
+func TestSocketRejectsOrigin(t *testing.T) {
+	defer func(origins originPolicy) {
+		_allowOrigins = origins
+	}(_allowOrigins)
+	_allowOrigins = originPolicy{"https://warn.example.org"}
+
+	p := newProxy(nil, nil)
+	srv := httptest.NewServer(http.HandlerFunc(p.socketHandler))
+	defer srv.Close()
+
+	for origin, allowed := range map[string]bool{"https://warn.example.org": true, "https://evil.example": false} {
+		conn, resp, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"), &websocket.DialOptions{
+			HTTPHeader: http.Header{"Origin": []string{origin}},
+		})
+		if allowed {
+			if err != nil {
+				t.Fatal(origin, "unexpected error", err)
+			}
+			conn.Close(websocket.StatusNormalClosure, "")
+			continue
+		}
+		if err == nil {
+			conn.Close(websocket.StatusNormalClosure, "")
+			t.Fatal(origin, "expected handshake to fail")
+		}
+		if resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Error(origin, "expected forbidden, got", resp)
+		}
+	}
+}
@@ -10,6 +10,69 @@ import (
 
 // This file contains the code for handling arbitrary lat/lon polygons.
 
+// Shape is a region on the surface of the earth
+type Shape interface {
+	Contains(c Coordinate) bool
+	Bounds() BoundingBox
+	// Distance returns the great-circle distance in km from c to the closest point of the shape, 0 if c is inside.
+	Distance(c Coordinate) float64
+	// Intersects returns true if the shape and a share at least one point.
+	Intersects(a Area) bool
+}
+
+// BoundingBox is the smallest lat/lon rectangle enclosing a shape
+type BoundingBox struct {
+	Min, Max Coordinate
+}
+
+// emptyBoundingBox is the neutral element of BoundingBox.extend
+var emptyBoundingBox = BoundingBox{
+	Min: Coordinate{math.Inf(1), math.Inf(1)},
+	Max: Coordinate{math.Inf(-1), math.Inf(-1)},
+}
+
+// extend returns the smallest bounding box enclosing b and c.
+func (b BoundingBox) extend(c Coordinate) BoundingBox {
+	return BoundingBox{
+		Min: Coordinate{math.Min(b.Min.Latitude, c.Latitude), math.Min(b.Min.Longitude, c.Longitude)},
+		Max: Coordinate{math.Max(b.Max.Latitude, c.Latitude), math.Max(b.Max.Longitude, c.Longitude)},
+	}
+}
+
+// Empty returns true if b encloses no point at all.
+func (b BoundingBox) Empty() bool {
+	return b.Min.Latitude > b.Max.Latitude || b.Min.Longitude > b.Max.Longitude
+}
+
+// Contains returns true if c is inside b or on its boundary.
+func (b BoundingBox) Contains(c Coordinate) bool {
+	return c.Latitude >= b.Min.Latitude && c.Latitude <= b.Max.Latitude &&
+		c.Longitude >= b.Min.Longitude && c.Longitude <= b.Max.Longitude
+}
+
+// Intersects returns true if b and o share at least one point.
+func (b BoundingBox) Intersects(o BoundingBox) bool {
+	return b.Min.Latitude <= o.Max.Latitude && o.Min.Latitude <= b.Max.Latitude &&
+		b.Min.Longitude <= o.Max.Longitude && o.Min.Longitude <= b.Max.Longitude
+}
+
+// Area returns the rectangle described by b as an Area. It is empty if b is empty.
+func (b BoundingBox) Area() Area {
+	if b.Empty() {
+		return Area{}
+	}
+	return newAreaFromRings([][]Coordinate{{
+		b.Min,
+		{b.Min.Latitude, b.Max.Longitude},
+		b.Max,
+		{b.Max.Latitude, b.Min.Longitude},
+	}})
+}
+
+func (b BoundingBox) String() string {
+	return fmt.Sprintf("[%s-%s]", b.Min, b.Max)
+}
+
 type InvalidCoordinateError struct {
 	s string
 	v []string
@@ -48,6 +111,17 @@ func NewCoordinateFromString(s string) (Coordinate, error) {
 	return c, nil
 }
 
+// validate returns an error if c is not a valid coordinate, i.e. its latitude or longitude is out of range or NaN.
+func (c Coordinate) validate() error {
+	if math.IsNaN(c.Latitude) || c.Latitude < -90 || c.Latitude > 90 {
+		return fmt.Errorf("latitude %v out of range [-90, 90]", c.Latitude)
+	}
+	if math.IsNaN(c.Longitude) || c.Longitude < -180 || c.Longitude > 180 {
+		return fmt.Errorf("longitude %v out of range [-180, 180]", c.Longitude)
+	}
+	return nil
+}
+
 func (c Coordinate) String() string {
 	return fmt.Sprintf("[Lat:% 3.3f, Lon:% 3.3f]", c.Latitude, c.Longitude)
 }
@@ -105,58 +179,284 @@ func NewAreaFromString(coords string) (Area, error) {
 	return a, nil
 }
 
-// Contains returns true if c is inside the polygon described by a
-func (a Area) Contains(c Coordinate) bool {
-	// Cast a ray from c to the right. Count crossings with line segments of a. If the number of crossings is even, c is outside
-	// of a.
+// newAreaFromRings returns an area bounded by rings. Rings don't need to be closed. Since containment is decided by counting
+// crossings, rings inside other rings are holes.
+func newAreaFromRings(rings [][]Coordinate) Area {
+	var a Area
+	for _, ring := range rings {
+		if len(ring) < 2 {
+			continue
+		}
+		if ring[0] != ring[len(ring)-1] {
+			ring = append(ring[:len(ring):len(ring)], ring[0])
+		}
+		for i := 1; i < len(ring); i++ {
+			a.Segments = append(a.Segments, LineSegment{p1: ring[i-1], p2: ring[i]})
+		}
+	}
+	return a
+}
 
-	intersections := 0
+// onSegmentEpsilon is the tolerance for deciding whether a point lies on a line segment, in square degrees
+const onSegmentEpsilon = 1e-12
+
+// contains returns true if c lies on l, including its end points.
+func (l LineSegment) contains(c Coordinate) bool {
+	if c.Latitude < math.Min(l.p1.Latitude, l.p2.Latitude) || c.Latitude > math.Max(l.p1.Latitude, l.p2.Latitude) ||
+		c.Longitude < math.Min(l.p1.Longitude, l.p2.Longitude) || c.Longitude > math.Max(l.p1.Longitude, l.p2.Longitude) {
+		return false
+	}
+	// c is within the bounding box of l, so it is on l if it is on the line through l
+	cross := (l.p2.Longitude-l.p1.Longitude)*(c.Latitude-l.p1.Latitude) - (l.p2.Latitude-l.p1.Latitude)*(c.Longitude-l.p1.Longitude)
+	return math.Abs(cross) <= onSegmentEpsilon
+}
 
+// Bounds returns the bounding box of a. It is empty if a has no segments.
+func (a Area) Bounds() BoundingBox {
+	b := emptyBoundingBox
 	for _, seg := range a.Segments {
-		// Check if a is to the left of the rightmost part of seg and between the end points
-		// Check latitudes (Y coords)
-		minLat := math.Min(seg.p1.Latitude, seg.p2.Latitude)
-		maxLat := math.Max(seg.p1.Latitude, seg.p2.Latitude)
+		b = b.extend(seg.p1).extend(seg.p2)
+	}
+	return b
+}
 
-		if c.Latitude < minLat || c.Latitude > maxLat {
-			// Above or below line segment
-			continue
+// Contains returns true if c is inside the polygon described by a. Points on the boundary of a, including its vertices, are inside.
+// If a consists of several rings, c is inside if it is enclosed by an odd number of them, so rings inside other rings are holes.
+func (a Area) Contains(c Coordinate) bool {
+	// Cast a ray from c to the east and count crossings with the line segments of a. If the number of crossings is even, c is
+	// outside of a.
+	//
+	// Segments are treated as half-open in latitude: a segment is crossed if exactly one of its end points is north of c. This way
+	// a ray through a vertex is counted once if the polygon continues to the other side of the ray and zero or two times if it turns
+	// back, and horizontal segments are never counted.
+	inside := false
+
+	for _, seg := range a.Segments {
+		if seg.contains(c) {
+			return true
 		}
 
-		// Calculate x coordinate of the intersection of a line through seg.p1 and sec.p2 (l1) and a line to the right through c (l2)
-		if seg.p1.Longitude == seg.p2.Longitude {
-			// Deal with seg.p1 and seg.p2 on one vertical line
-			if c.Longitude <= seg.p1.Longitude {
-				intersections++
-			}
+		if (seg.p1.Latitude > c.Latitude) == (seg.p2.Latitude > c.Latitude) {
+			// Both end points on the same side of the ray
 			continue
 		}
 
-		// Get slope of l1
-		// Determine which of p1, p2 is leftmost
-		leftP := seg.p1
-		rightP := seg.p2
-		if leftP.Longitude > rightP.Longitude {
-			leftP = seg.p2
-			rightP = seg.p1
+		// Longitude of the intersection of the ray with the line through seg
+		lonColl := seg.p1.Longitude + (c.Latitude-seg.p1.Latitude)*(seg.p2.Longitude-seg.p1.Longitude)/(seg.p2.Latitude-seg.p1.Latitude)
+		if c.Longitude < lonColl {
+			inside = !inside
 		}
-		// Calculate slope
-		slope := (rightP.Latitude - leftP.Latitude) / (rightP.Longitude - leftP.Longitude)
-		if slope == 0 {
-			// This segment is a horizontal line. Just compare its latitude with the latitude of the point we're testing.
-			if c.Latitude == leftP.Latitude && c.Longitude <= leftP.Longitude {
-				intersections++
+	}
+
+	return inside
+}
+
+// orientation returns the sign of the cross product of b-a and c-a: positive if a, b and c turn counterclockwise, negative if they
+// turn clockwise and 0 if they are collinear.
+func orientation(a, b, c Coordinate) float64 {
+	cross := (b.Longitude-a.Longitude)*(c.Latitude-a.Latitude) - (b.Latitude-a.Latitude)*(c.Longitude-a.Longitude)
+	if math.Abs(cross) <= onSegmentEpsilon {
+		return 0
+	}
+	return cross
+}
+
+// intersects returns true if l and o share at least one point, including touching end points and overlapping collinear segments.
+func (l LineSegment) intersects(o LineSegment) bool {
+	d1 := orientation(o.p1, o.p2, l.p1)
+	d2 := orientation(o.p1, o.p2, l.p2)
+	d3 := orientation(l.p1, l.p2, o.p1)
+	d4 := orientation(l.p1, l.p2, o.p2)
+
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		// Proper crossing
+		return true
+	}
+	// Touching or collinear
+	return o.contains(l.p1) || o.contains(l.p2) || l.contains(o.p1) || l.contains(o.p2)
+}
+
+// Intersects returns true if a and b share at least one point. Touching boundaries count as intersection.
+func (a Area) Intersects(b Area) bool {
+	if len(a.Segments) == 0 || len(b.Segments) == 0 || !a.Bounds().Intersects(b.Bounds()) {
+		return false
+	}
+
+	// Either the boundaries cross, or one area lies completely inside the other one
+	for _, sa := range a.Segments {
+		for _, sb := range b.Segments {
+			if sa.intersects(sb) {
+				return true
 			}
-			continue
 		}
+	}
+	return a.Contains(b.Segments[0].p1) || b.Contains(a.Segments[0].p1)
+}
 
-		// Longitude of collision point is: Long = (Lat_c)/d
-		lonColl := c.Latitude / slope
+// containsArea returns true if all vertices of b are inside a or on its boundary.
+func (a Area) containsArea(b Area) bool {
+	if len(b.Segments) == 0 {
+		return false
+	}
+	for _, seg := range b.Segments {
+		if !a.Contains(seg.p1) {
+			return false
+		}
+	}
+	return true
+}
 
-		if lonColl <= c.Longitude {
-			intersections++
+// excludeAreas returns areas with the excluded areas inside of them cut out. Since containment is decided by counting crossings,
+// the boundary of an excluded area added to an area turns its inside into a hole. Excluded areas not completely inside an area
+// are ignored for it.
+func excludeAreas(areas, excluded []Area) []Area {
+	if len(excluded) == 0 {
+		return areas
+	}
+	result := make([]Area, 0, len(areas))
+	for _, a := range areas {
+		cut := Area{Segments: append([]LineSegment(nil), a.Segments...)}
+		for _, e := range excluded {
+			if a.containsArea(e) {
+				cut.Segments = append(cut.Segments, e.Segments...)
+			}
 		}
+		result = append(result, cut)
+	}
+	return result
+}
+
+// earthRadius is the mean radius of the earth in km
+const earthRadius = 6371.0
+
+// distance returns the great-circle distance between a and b in km.
+func distance(a, b Coordinate) float64 {
+	const rad = math.Pi / 180
+
+	dLat := (b.Latitude - a.Latitude) * rad
+	dLon := (b.Longitude - a.Longitude) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(a.Latitude*rad)*math.Cos(b.Latitude*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// bearing returns the initial bearing of the great circle from a to b in radians.
+func bearing(a, b Coordinate) float64 {
+	const rad = math.Pi / 180
+
+	dLon := (b.Longitude - a.Longitude) * rad
+	y := math.Sin(dLon) * math.Cos(b.Latitude*rad)
+	x := math.Cos(a.Latitude*rad)*math.Sin(b.Latitude*rad) - math.Sin(a.Latitude*rad)*math.Cos(b.Latitude*rad)*math.Cos(dLon)
+	return math.Atan2(y, x)
+}
+
+// distanceToSegment returns the great-circle distance in km from c to the closest point of l, treating l as a great circle arc.
+func distanceToSegment(c Coordinate, l LineSegment) float64 {
+	d12 := distance(l.p1, l.p2) / earthRadius
+	d13 := distance(l.p1, c) / earthRadius
+	if d12 == 0 {
+		return d13 * earthRadius
+	}
+
+	theta := bearing(l.p1, c) - bearing(l.p1, l.p2)
+	if math.Cos(theta) <= 0 {
+		// c is behind p1
+		return d13 * earthRadius
+	}
+
+	// Cross-track distance from the great circle through l and along-track distance of the closest point from p1
+	xt := math.Asin(math.Sin(d13) * math.Sin(theta))
+	at := math.Acos(math.Max(-1, math.Min(1, math.Cos(d13)/math.Cos(xt))))
+	if at >= d12 {
+		// The closest point of the great circle is beyond p2
+		return distance(l.p2, c)
+	}
+	return math.Abs(xt) * earthRadius
+}
+
+// Distance returns the great-circle distance in km from c to the boundary of a, or 0 if c is inside a. It returns +Inf if a is
+// empty.
+func (a Area) Distance(c Coordinate) float64 {
+	if a.Contains(c) {
+		return 0
+	}
+	d := math.Inf(1)
+	for _, seg := range a.Segments {
+		d = math.Min(d, distanceToSegment(c, seg))
+	}
+	return d
+}
+
+// Circle is a CAP circle area
+type Circle struct {
+	Center Coordinate
+	Radius float64 // in km
+}
+
+// NewCircleFromString returns the Circle described by s. s has the following layout:
+//
+//	s := "50.1,7.8 10" // Latitude, Longitude Radius in km
+//
+// Unlike in polygons, the latitude comes first.
+func NewCircleFromString(s string) (Circle, error) {
+	var c Circle
+	v := strings.Fields(s)
+	if len(v) != 2 {
+		return c, fmt.Errorf("malformed circle '%s'", s)
+	}
+
+	// Reuse the polygon coordinate parser, the order of latitude and longitude is swapped
+	center, err := NewCoordinateFromString(v[0])
+	if err != nil {
+		return c, err
+	}
+	c.Center = Coordinate{Latitude: center.Longitude, Longitude: center.Latitude}
+
+	c.Radius, err = strconv.ParseFloat(v[1], 64)
+	if err != nil {
+		return c, fmt.Errorf("parsing radius: %w", err)
+	}
+	if c.Radius < 0 {
+		return c, fmt.Errorf("negative radius in circle '%s'", s)
 	}
 
-	return intersections%2 != 0
+	return c, nil
+}
+
+func (c Circle) String() string {
+	return fmt.Sprintf("[%s r=%.3fkm]", c.Center, c.Radius)
+}
+
+// Bounds returns the bounding box of c. Circles that extend to a pole span all longitudes.
+func (c Circle) Bounds() BoundingBox {
+	// Length of one degree of latitude in km
+	const kmPerDegree = earthRadius * math.Pi / 180
+
+	dLat := c.Radius / kmPerDegree
+	b := BoundingBox{
+		Min: Coordinate{math.Max(-90, c.Center.Latitude-dLat), -180},
+		Max: Coordinate{math.Min(90, c.Center.Latitude+dLat), 180},
+	}
+	if b.Min.Latitude > -90 && b.Max.Latitude < 90 {
+		// The circle is widest at the latitude closest to the pole
+		maxLat := math.Max(math.Abs(b.Min.Latitude), math.Abs(b.Max.Latitude))
+		dLon := c.Radius / (kmPerDegree * math.Cos(maxLat*math.Pi/180))
+		b.Min.Longitude = c.Center.Longitude - dLon
+		b.Max.Longitude = c.Center.Longitude + dLon
+	}
+	return b
+}
+
+// Contains returns true if co is inside c or on its boundary.
+func (c Circle) Contains(co Coordinate) bool {
+	return distance(c.Center, co) <= c.Radius
+}
+
+// Distance returns the great-circle distance in km from co to the boundary of c, or 0 if co is inside c.
+func (c Circle) Distance(co Coordinate) float64 {
+	return math.Max(0, distance(c.Center, co)-c.Radius)
+}
+
+// Intersects returns true if c and a share at least one point.
+func (c Circle) Intersects(a Area) bool {
+	return len(a.Segments) > 0 && a.Distance(c.Center) <= c.Radius
 }
@@ -1,6 +1,11 @@
 package main
 
-import "testing"
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
 
 const (
 	_testArea1 = `13.09,50.783 13.109,50.79 13.139,50.77 13.17,50.784 13.196,50.784 13.242,50.772 13.277,50.763 13.284,50.74 13.333,50.746 13.358,50.753 13.364,50.753 13.378,50.735 13.37,50.716 13.406,50.685 13.433,50.676 13.431,50.665 13.485,50.65 13.485,50.641 13.501,50.633 13.464,50.603 13.428,50.611 13.425,50.616 13.42,50.615 13.391,50.645 13.373,50.644 13.369,50.618 13.321,50.601 13.325,50.583 13.291,50.575 13.279,50.593 13.256,50.595 13.235,50.578 13.229,50.554 13.195,50.503 13.175,50.504 13.137,50.506 13.127,50.517 13.103,50.503 13.061,50.501 13.044,50.511 13.033,50.509 13.022,50.477 13.024,50.451 12.966,50.415 12.936,50.411 12.894,50.43 12.836,50.454 12.807,50.442 12.795,50.449 12.754,50.438 12.696,50.401 12.666,50.414 12.628,50.415 12.583,50.407 12.584,50.424 12.533,50.445 12.494,50.469 12.46,50.497 12.467,50.514 12.471,50.518 12.477,50.523 12.512,50.53 12.529,50.546 12.548,50.561 12.582,50.552 12.613,50.565 12.584,50.575 12.589,50.609 12.631,50.62 12.64,50.636 12.648,50.64 12.687,50.629 12.698,50.636 12.711,50.643 12.708,50.666 12.718,50.687 12.705,50.7 12.683,50.7 12.653,50.71 12.642,50.722 12.666,50.732 12.65,50.754 12.686,50.755 12.695,50.739 12.714,50.738 12.727,50.752 12.713,50.77 12.74,50.78 12.755,50.771 12.778,50.789 12.796,50.785 12.817,50.789 12.84,50.801 12.852,50.797 12.889,50.784 12.895,50.754 12.906,50.748 12.938,50.744 12.953,50.759 12.967,50.755 13.005,50.771 13.019,50.771 13.047,50.807 13.072,50.785 13.09,50.783`
@@ -64,3 +69,330 @@ func TestAreaFromString(t *testing.T) {
 		t.Fatal("unexpected error", err)
 	}
 }
+
+func TestContainsBoundary(t *testing.T) {
+	square, _ := NewAreaFromString(_testArea2)
+	// Diamond with vertices at the latitude of test points
+	diamond, _ := NewAreaFromString("0,-1 1,0 0,1 -1,0")
+	// Polygon with a notch whose tip points at the origin from the east
+	notched, _ := NewAreaFromString("-2,-2 2,-2 2,-1 1,0 2,1 2,2 -2,2")
+	// Polygon with a horizontal edge at latitude 0
+	stepped, _ := NewAreaFromString("-2,-2 2,-2 2,0 1,0 1,2 -2,2")
+
+	testCases := []struct {
+		a        Area
+		expected bool
+		c        Coordinate
+	}{
+		{square, true, Coordinate{1, 0}},      // On edge
+		{square, true, Coordinate{1, 1}},      // On vertex
+		{square, false, Coordinate{2, 1}},     // On extension of vertical edge
+		{square, false, Coordinate{1, 2}},     // On extension of horizontal edge
+		{square, false, Coordinate{1, -1.5}},  // Ray along horizontal edge
+		{diamond, true, Coordinate{0, 0}},     // Ray through vertex, polygon continues on the other side
+		{diamond, false, Coordinate{0, -2}},   // Ray through two vertices
+		{diamond, true, Coordinate{0.5, 0.5}}, // On edge
+		{diamond, false, Coordinate{1, -0.1}}, // Ray through top vertex, polygon turns back
+		{notched, true, Coordinate{0, 0}},     // Ray through notch tip, polygon turns back
+		{notched, false, Coordinate{0, 1.5}},  // Inside notch
+		{notched, true, Coordinate{0, 1}},     // Notch tip
+		{stepped, true, Coordinate{0, 0}},     // Ray along horizontal edge
+		{stepped, true, Coordinate{0, 1.5}},   // On horizontal edge
+		{stepped, false, Coordinate{1, 1.5}},  // Above horizontal edge, outside
+		{Area{}, false, Coordinate{0, 0}},     // Empty area
+	}
+
+	for _, testCase := range testCases {
+		is := testCase.a.Contains(testCase.c)
+		if is != testCase.expected {
+			t.Error("area:", testCase.a, "expected:", testCase.c, "=", testCase.expected, "got:", is)
+		}
+	}
+}
+
+func TestContainsDistrict(t *testing.T) {
+	// _testArea1 is the Erzgebirgskreis in Saxony
+	a, err := NewAreaFromString(_testArea1)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	testCases := []testCase{
+		{true, Coordinate{50.58, 13.00}},  // Annaberg-Buchholz
+		{true, Coordinate{50.59, 12.70}},  // Aue
+		{true, Coordinate{50.65, 13.16}},  // Marienberg
+		{true, Coordinate{50.42, 12.97}},  // Oberwiesenthal
+		{true, Coordinate{50.545, 12.78}}, // Schwarzenberg
+		{true, Coordinate{50.71, 12.78}},  // Stollberg
+		{true, Coordinate{50.66, 13.34}},  // Olbernhau
+		{false, Coordinate{50.83, 12.92}}, // Chemnitz
+		{false, Coordinate{50.72, 12.49}}, // Zwickau
+		{false, Coordinate{50.91, 13.34}}, // Freiberg
+		{false, Coordinate{50.23, 12.87}}, // Karlovy Vary
+	}
+
+	for _, testCase := range testCases {
+		is := a.Contains(testCase.c)
+		if is != testCase.expected {
+			t.Error("expected:", testCase.c, "=", testCase.expected, "got:", is)
+		}
+	}
+}
+
+// windingNumber is a reference implementation of point in polygon tests. It returns the number of times the segments of a wind
+// around c, which is non-zero if c is inside a simple polygon.
+func windingNumber(a Area, c Coordinate) int {
+	isLeft := func(seg LineSegment) float64 {
+		return (seg.p2.Longitude-seg.p1.Longitude)*(c.Latitude-seg.p1.Latitude) - (c.Longitude-seg.p1.Longitude)*(seg.p2.Latitude-seg.p1.Latitude)
+	}
+
+	wn := 0
+	for _, seg := range a.Segments {
+		if seg.p1.Latitude <= c.Latitude {
+			if seg.p2.Latitude > c.Latitude && isLeft(seg) > 0 {
+				wn++
+			}
+		} else if seg.p2.Latitude <= c.Latitude && isLeft(seg) < 0 {
+			wn--
+		}
+	}
+	return wn
+}
+
+// randomPolygon returns a random simple polygon. Its vertices are spread around center at increasing angles, so it is star-shaped
+// but usually not convex.
+func randomPolygon(r *rand.Rand, center Coordinate) Area {
+	n := 3 + r.Intn(30)
+	angles := make([]float64, n)
+	for i := range angles {
+		angles[i] = r.Float64() * 2 * math.Pi
+	}
+	sort.Float64s(angles)
+
+	ring := make([]Coordinate, 0, n)
+	for _, angle := range angles {
+		radius := 0.1 + r.Float64()
+		ring = append(ring, Coordinate{center.Latitude + radius*math.Sin(angle), center.Longitude + radius*math.Cos(angle)})
+	}
+	return newAreaFromRings([][]Coordinate{ring})
+}
+
+func TestContainsMatchesReference(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 1000; i++ {
+		center := Coordinate{47 + r.Float64()*8, 6 + r.Float64()*9}
+		a := randomPolygon(r, center)
+		for j := 0; j < 100; j++ {
+			c := Coordinate{center.Latitude + (r.Float64()*2.4 - 1.2), center.Longitude + (r.Float64()*2.4 - 1.2)}
+			// Also test points at the latitude of a vertex, where rays pass through vertices
+			if j%10 == 0 {
+				c.Latitude = a.Segments[r.Intn(len(a.Segments))].p1.Latitude
+			}
+			expected := windingNumber(a, c) != 0
+			onBoundary := false
+			for _, seg := range a.Segments {
+				onBoundary = onBoundary || seg.contains(c)
+			}
+			if onBoundary {
+				continue
+			}
+			if is := a.Contains(c); is != expected {
+				t.Fatalf("polygon %s: expected: %s = %t got: %t", a.Segments, c, expected, is)
+			}
+		}
+	}
+}
+
+func TestContainsHoles(t *testing.T) {
+	a := newAreaFromRings([][]Coordinate{
+		{{0, 0}, {0, 10}, {10, 10}, {10, 0}},
+		{{2, 2}, {2, 8}, {8, 8}, {8, 2}},
+	})
+
+	testCases := []testCase{
+		{true, Coordinate{1, 1}},
+		{false, Coordinate{5, 5}},
+		{true, Coordinate{2, 5}}, // On the boundary of the hole
+		{false, Coordinate{11, 5}},
+	}
+	for _, testCase := range testCases {
+		is := a.Contains(testCase.c)
+		if is != testCase.expected {
+			t.Error("expected:", testCase.c, "=", testCase.expected, "got:", is)
+		}
+	}
+}
+
+func TestAreaIntersects(t *testing.T) {
+	square := newAreaFromRings([][]Coordinate{{{0, 0}, {0, 10}, {10, 10}, {10, 0}}})
+	withHole := newAreaFromRings([][]Coordinate{
+		{{0, 0}, {0, 10}, {10, 10}, {10, 0}},
+		{{2, 2}, {2, 8}, {8, 8}, {8, 2}},
+	})
+
+	testCases := []struct {
+		name     string
+		a, b     Area
+		expected bool
+	}{
+		{"crossing", square, BoundingBox{Coordinate{5, 5}, Coordinate{15, 15}}.Area(), true},
+		{"inside", square, BoundingBox{Coordinate{4, 4}, Coordinate{6, 6}}.Area(), true},
+		{"enclosing", BoundingBox{Coordinate{4, 4}, Coordinate{6, 6}}.Area(), square, true},
+		{"touching", square, BoundingBox{Coordinate{10, 10}, Coordinate{12, 12}}.Area(), true},
+		{"disjoint", square, BoundingBox{Coordinate{11, 11}, Coordinate{12, 12}}.Area(), false},
+		// The bounding boxes overlap, but the triangle is beyond the diagonal
+		{"disjoint triangle", newAreaFromRings([][]Coordinate{{{0, 0}, {0, 10}, {10, 0}}}), BoundingBox{Coordinate{8, 8}, Coordinate{9, 9}}.Area(), false},
+		{"in hole", withHole, BoundingBox{Coordinate{4, 4}, Coordinate{6, 6}}.Area(), false},
+		{"across hole", withHole, BoundingBox{Coordinate{4, 4}, Coordinate{6, 12}}.Area(), true},
+		{"empty", square, Area{}, false},
+	}
+	for _, testCase := range testCases {
+		if is := testCase.a.Intersects(testCase.b); is != testCase.expected {
+			t.Errorf("%s: expected %t, got %t", testCase.name, testCase.expected, is)
+		}
+		if is := testCase.b.Intersects(testCase.a); is != testCase.expected {
+			t.Errorf("%s reversed: expected %t, got %t", testCase.name, testCase.expected, is)
+		}
+	}
+
+	c := Circle{Center: Coordinate{50, 10}, Radius: 10}
+	if !c.Intersects(BoundingBox{Coordinate{50.05, 10.05}, Coordinate{51, 11}}.Area()) {
+		t.Error("expected circle to intersect overlapping box")
+	}
+	if c.Intersects(BoundingBox{Coordinate{50.2, 10.2}, Coordinate{51, 11}}.Area()) {
+		t.Error("expected circle not to intersect distant box")
+	}
+}
+
+func TestCoordinateValidate(t *testing.T) {
+	valid := []Coordinate{{0, 0}, {90, 180}, {-90, -180}, {48.8345, 8.3819}}
+	for _, c := range valid {
+		if err := c.validate(); err != nil {
+			t.Error(c, "unexpected error", err)
+		}
+	}
+	invalid := []Coordinate{{90.1, 0}, {-91, 0}, {0, 180.5}, {0, -200}, {math.NaN(), 0}, {0, math.NaN()}, {math.Inf(1), 0}}
+	for _, c := range invalid {
+		if err := c.validate(); err == nil {
+			t.Error("expected error for", c)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	berlin := Coordinate{52.5200, 13.4050}
+	munich := Coordinate{48.1351, 11.5820}
+	if d := distance(berlin, munich); math.Abs(d-504.4) > 1 {
+		t.Errorf("expected distance Berlin-Munich of about 504km, got %.1fkm", d)
+	}
+	if d := distance(berlin, berlin); d != 0 {
+		t.Errorf("expected zero distance, got %f", d)
+	}
+}
+
+func TestCircle(t *testing.T) {
+	c, err := NewCircleFromString("50.73,7.1 2.5")
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if c.Center != (Coordinate{50.73, 7.1}) || c.Radius != 2.5 {
+		t.Error("unexpected circle", c)
+	}
+
+	testCases := []testCase{
+		{true, Coordinate{50.73, 7.1}},
+		{true, Coordinate{50.75, 7.1}},  // ~2.2km north
+		{false, Coordinate{50.76, 7.1}}, // ~3.3km north
+		{true, Coordinate{50.73, 7.13}}, // ~2.1km east
+		{false, Coordinate{50.73, 7.14}},
+	}
+	for _, testCase := range testCases {
+		if is := c.Contains(testCase.c); is != testCase.expected {
+			t.Error("expected:", testCase.c, "=", testCase.expected, "got:", is)
+		}
+	}
+
+	for _, s := range []string{"", "50.73,7.1", "50.73 7.1 2", "50.73,7.1 x", "50.73,7.1 -1"} {
+		if _, err := NewCircleFromString(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestExcludeAreas(t *testing.T) {
+	county, _ := NewAreaFromString("0,0 10,0 10,10 0,10 0,0")
+	exclave, _ := NewAreaFromString("20,20 21,20 21,21 20,21 20,20")
+	city, _ := NewAreaFromString("2,2 8,2 8,8 2,8 2,2")
+	across, _ := NewAreaFromString("9,9 12,9 12,12 9,12 9,9")
+
+	areas := excludeAreas([]Area{county, exclave}, []Area{city, across})
+	if len(areas) != 2 {
+		t.Fatal("expected two areas, got", areas)
+	}
+
+	testCases := []testCase{
+		{true, Coordinate{1, 1}},        // County
+		{false, Coordinate{3, 3}},       // Excluded city
+		{true, Coordinate{9.5, 9.5}},    // Partly outside exclusion is ignored
+		{false, Coordinate{11, 11}},     // Outside
+		{true, Coordinate{20.5, 20.5}},  // Exclave
+		{false, Coordinate{15.5, 15.5}}, // Outside
+	}
+	for _, testCase := range testCases {
+		is := false
+		for _, a := range areas {
+			is = is || a.Contains(testCase.c)
+		}
+		if is != testCase.expected {
+			t.Error("expected:", testCase.c, "=", testCase.expected, "got:", is)
+		}
+	}
+}
+
+func TestDistanceToSegment(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		l := LineSegment{
+			p1: Coordinate{47 + r.Float64()*8, 6 + r.Float64()*9},
+			p2: Coordinate{47 + r.Float64()*8, 6 + r.Float64()*9},
+		}
+		c := Coordinate{47 + r.Float64()*8, 6 + r.Float64()*9}
+
+		// Sample points along the great circle arc between p1 and p2
+		d12 := distance(l.p1, l.p2) / earthRadius
+		theta := bearing(l.p1, l.p2)
+		lat1, lon1 := l.p1.Latitude*math.Pi/180, l.p1.Longitude*math.Pi/180
+		sampled := math.Inf(1)
+		for j := 0; j <= 1000; j++ {
+			d := d12 * float64(j) / 1000
+			lat := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(theta))
+			lon := lon1 + math.Atan2(math.Sin(theta)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat))
+			sampled = math.Min(sampled, distance(c, Coordinate{lat * 180 / math.Pi, lon * 180 / math.Pi}))
+		}
+
+		// The sampled distance is an upper bound, off by at most half the sample spacing
+		d := distanceToSegment(c, l)
+		if d > sampled+1e-6 || sampled-d > d12*earthRadius/2000+1e-6 {
+			t.Fatalf("%s to %s: expected %f, got %f", c, l, sampled, d)
+		}
+	}
+}
+
+func TestAreaDistance(t *testing.T) {
+	a, _ := NewAreaFromString(_testArea2)
+	if d := a.Distance(Coordinate{0, 0}); d != 0 {
+		t.Errorf("expected zero distance inside area, got %f", d)
+	}
+	// One degree of latitude is about 111.2km
+	if d := a.Distance(Coordinate{2, 0}); math.Abs(d-111.2) > 0.1 {
+		t.Errorf("expected distance of about 111.2km, got %f", d)
+	}
+	// Edges are great circle arcs, which bulge slightly towards the pole
+	if d := a.Distance(Coordinate{1.01, 0.5}); math.Abs(d-1.112) > 0.02 {
+		t.Errorf("expected distance of about 1.1km, got %f", d)
+	}
+	if d := (Area{}).Distance(Coordinate{0, 0}); !math.IsInf(d, 1) {
+		t.Errorf("expected infinite distance to empty area, got %f", d)
+	}
+}
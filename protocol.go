@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// This file contains the messages exchanged with websocket clients.
+//
+// Version 1 of the protocol is the original one: clients send bare locations and receive bare arrays of alerts. Errors are reported
+// as objects, so they can't be mistaken for alerts. Version 2 wraps all messages in a typed envelope and is negotiated with the
+// websocket subprotocol protocolV2.
+
+// Websocket subprotocols, in order of preference
+const (
+	protocolV2 = "openwarn.v2"
+	protocolV1 = "openwarn.v1"
+)
+
+var subprotocols = []string{protocolV2, protocolV1}
+
+// protocolVersion returns the protocol version of the negotiated websocket subprotocol. Clients not negotiating a subprotocol use
+// version 1.
+func protocolVersion(subprotocol string) int {
+	if subprotocol == protocolV2 {
+		return 2
+	}
+	return 1
+}
+
+// Client commands, sent in the type field of a clientMessage
+const (
+	cmdSubscribe = "subscribe"
+	cmdAdd       = "add"
+	cmdRemove    = "remove"
+	cmdList      = "list"
+	cmdViewport  = "viewport"
+	cmdFilter    = "filter"
+)
+
+// Server events, sent in the type field of the envelope
+const (
+	eventAlerts    = "alerts"
+	eventAdded     = "added"
+	eventUpdated   = "updated"
+	eventRemoved   = "removed"
+	eventLocations = "locations"
+	eventError     = "error"
+)
+
+// Reasons for removing an alert
+const (
+	reasonRetracted = "retracted" // Cancelled or superseded by its sender
+	reasonExpired   = "expired"
+	reasonUnmatched = "unmatched" // No longer matching the subscription, e.g. after its area changed
+)
+
+// header is the envelope of all messages. Version is omitted in messages to version 1 clients. Seq is the sequence number of the
+// change of the active alerts an event describes. It increases with every change, events caused by the same change share it.
+// Sequence numbers restart with every run of the proxy, Epoch identifies the run they belong to.
+type header struct {
+	Type    string `json:"type"`
+	Version int    `json:"version,omitempty"`
+	Seq     uint64 `json:"seq,omitempty"`
+	Epoch   string `json:"epoch,omitempty"`
+}
+
+// clientMessage is a message sent by a client. Messages without a type are bare locations sent by version 1 clients, they replace
+// the location with the empty name.
+type clientMessage struct {
+	header
+	Name string `json:"name"`
+	Location
+	// Locations replace all locations of the client in the subscribe command
+	Locations map[string]Location `json:"locations,omitempty"`
+	// BBox and Polygon describe the viewport of the subscribe and viewport commands
+	BBox    *BoundingBox `json:"bbox,omitempty"`
+	Polygon []Coordinate `json:"polygon,omitempty"`
+	// Filter replaces the filter of the client, it may be sent along with any command
+	Filter *alertFilter `json:"filter,omitempty"`
+	// Since is the sequence number of the last event received before reconnecting, the subscribe command replays the events
+	// missed since then. The epoch of that event must be sent along with it.
+	Since uint64 `json:"since,omitempty"`
+}
+
+// coordinateFields are the coordinate fields present in a message. Missing or misspelled fields would decode to 0 otherwise.
+type coordinateFields struct {
+	Latitude, Longitude *float64
+}
+
+// missing returns an error if a coordinate field is missing.
+func (f coordinateFields) missing() error {
+	switch {
+	case f.Latitude == nil:
+		return errors.New("latitude missing")
+	case f.Longitude == nil:
+		return errors.New("longitude missing")
+	}
+	return nil
+}
+
+// checkCoordinates returns an error if the message raw of type t lacks coordinates its command requires.
+func checkCoordinates(t string, raw []byte) error {
+	var fields struct {
+		coordinateFields
+		Locations map[string]coordinateFields
+	}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return err
+	}
+	switch t {
+	case "", cmdAdd:
+		return fields.missing()
+	case cmdSubscribe:
+		for name, f := range fields.Locations {
+			if err := f.missing(); err != nil {
+				return fmt.Errorf("location %q: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// viewport returns the region described by the bbox or polygon of msg. It returns nil if msg describes no region.
+func (msg clientMessage) viewport() (*Area, error) {
+	switch {
+	case msg.BBox != nil && msg.Polygon != nil:
+		return nil, errors.New("viewport must be either a bbox or a polygon")
+	case msg.BBox != nil:
+		for _, c := range []Coordinate{msg.BBox.Min, msg.BBox.Max} {
+			if err := c.validate(); err != nil {
+				return nil, fmt.Errorf("invalid bbox: %w", err)
+			}
+		}
+		if msg.BBox.Empty() {
+			return nil, fmt.Errorf("empty bbox %s", msg.BBox)
+		}
+		a := msg.BBox.Area()
+		return &a, nil
+	case msg.Polygon != nil:
+		if len(msg.Polygon) < 3 {
+			return nil, errors.New("polygon needs at least 3 coordinates")
+		}
+		for _, c := range msg.Polygon {
+			if err := c.validate(); err != nil {
+				return nil, fmt.Errorf("invalid polygon: %w", err)
+			}
+		}
+		a := newAreaFromRings([][]Coordinate{msg.Polygon})
+		return &a, nil
+	}
+	return nil, nil
+}
+
+// matchedAlert is an alert sent to a client, together with the names of the client's locations it affects and whether it intersects
+// the viewport. Alerts only affecting the location with the empty name have neither, so version 1 clients receive plain alert
+// messages.
+type matchedAlert struct {
+	alertMessage
+	Locations []string `json:"locations,omitempty"`
+	Viewport  bool     `json:"viewport,omitempty"`
+}
+
+// alertsEvent carries alerts to a client. The alerts event contains all alerts matching the subscription of the client and is the
+// reply to commands changing the subscription. Version 1 clients receive the bare array. Added and updated events are sent if the
+// alerts matching the subscription change.
+type alertsEvent struct {
+	header
+	Alerts []matchedAlert `json:"alerts"`
+	// Replaced lists the alerts superseded by the alerts of an updated event
+	Replaced []alertRef `json:"replaced,omitempty"`
+}
+
+// alertRef identifies an alert
+type alertRef struct {
+	Feed       SourceName `json:"feed"`
+	Identifier MessageID  `json:"identifier"`
+}
+
+type removedAlert struct {
+	alertRef
+	Reason string `json:"reason"`
+}
+
+// removedEvent lists the alerts that no longer match the subscription of a client
+type removedEvent struct {
+	header
+	Alerts []removedAlert `json:"alerts"`
+}
+
+// locationsEvent is the reply to the list command
+type locationsEvent struct {
+	header
+	Locations map[string]Location `json:"locations"`
+}
+
+// errorEvent reports an invalid command. Version 1 clients receive it without version.
+type errorEvent struct {
+	header
+	Error   string `json:"error"`
+	Command string `json:"command,omitempty"` // Type of the failed command
+}
+
+// encodeEvent returns the message to send for ev in the given protocol version.
+func encodeEvent(ev interface{}, version int) interface{} {
+	if version >= 2 {
+		return ev
+	}
+	if ev, ok := ev.(alertsEvent); ok {
+		return ev.Alerts
+	}
+	return ev
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// dialTestProxy connects to a proxy serving alerts for the square around 0,0, negotiating the given subprotocols. The returned
+// function closes the connection and the proxy.
+func dialTestProxy(t *testing.T, subprotocols ...string) (*websocket.Conn, func()) {
+	p := newProxy(nil, nil)
+	p.publish("test", newSourceSnapshot("test", []alertMessage{testAlert("a", _testArea2)}, nil, testLog))
+	return dial(t, p, subprotocols...)
+}
+
+// dial connects to p, negotiating the given subprotocols. The returned function closes the connection and the server.
+func dial(t *testing.T, p *Proxy, subprotocols ...string) (*websocket.Conn, func()) {
+	srv := httptest.NewServer(http.HandlerFunc(p.socketHandler))
+
+	conn, _, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"), &websocket.DialOptions{
+		Subprotocols: subprotocols,
+	})
+	if err != nil {
+		srv.Close()
+		t.Fatal("unexpected error", err)
+	}
+	return conn, func() {
+		conn.Close(websocket.StatusNormalClosure, "")
+		srv.Close()
+	}
+}
+
+// exchange sends msg to conn and returns the raw reply.
+func exchange(t *testing.T, conn *websocket.Conn, msg string) []byte {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := conn.Write(ctx, websocket.MessageText, []byte(msg)); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	_, reply, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	return reply
+}
+
+func TestProtocolV2(t *testing.T) {
+	conn, closeConn := dialTestProxy(t, protocolV2, protocolV1)
+	defer closeConn()
+	if conn.Subprotocol() != protocolV2 {
+		t.Fatal("expected v2 to be negotiated, got", conn.Subprotocol())
+	}
+
+	var alerts alertsEvent
+	reply := exchange(t, conn, `{"type": "subscribe", "version": 2, "locations": {"home": {"Latitude": 0, "Longitude": 0}}}`)
+	if err := json.Unmarshal(reply, &alerts); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if alerts.Type != eventAlerts || alerts.Version != 2 || len(alerts.Alerts) != 1 || alerts.Alerts[0].Locations[0] != "home" {
+		t.Error("unexpected reply", string(reply))
+	}
+
+	var e errorEvent
+	reply = exchange(t, conn, `{"type": "remove", "version": 2, "name": "work"}`)
+	if err := json.Unmarshal(reply, &e); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if e.Type != eventError || e.Command != cmdRemove || e.Error == "" {
+		t.Error("unexpected reply", string(reply))
+	}
+
+	reply = exchange(t, conn, `{"type": "list", "version": 3}`)
+	if err := json.Unmarshal(reply, &e); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if e.Type != eventError {
+		t.Error("expected error for unsupported version, got", string(reply))
+	}
+
+	reply = exchange(t, conn, `{"type": "add", "name": "work", "Latitude": "north"}`)
+	if err := json.Unmarshal(reply, &e); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if e.Type != eventError || !strings.Contains(e.Error, "malformed") {
+		t.Error("expected error for malformed message, got", string(reply))
+	}
+
+	reply = exchange(t, conn, `{"type": "add", "name": "work", "Latitude": 120, "Longitude": 0}`)
+	if err := json.Unmarshal(reply, &e); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if e.Type != eventError || e.Command != cmdAdd {
+		t.Error("expected error for invalid coordinate, got", string(reply))
+	}
+}
+
+func TestProtocolV1(t *testing.T) {
+	for _, subprotocols := range [][]string{nil, {protocolV1}} {
+		conn, closeConn := dialTestProxy(t, subprotocols...)
+		defer closeConn()
+
+		// Errors are objects instead of arrays
+		for _, msg := range []string{`{"type": "bogus"}`, `x`, `{}`, `{"Latitude": 91, "Longitude": 0}`} {
+			var e errorEvent
+			reply := exchange(t, conn, msg)
+			if err := json.Unmarshal(reply, &e); err != nil {
+				t.Fatal(subprotocols, "expected error object, got", string(reply))
+			}
+			if e.Type != eventError || e.Version != 0 || e.Error == "" {
+				t.Error(subprotocols, "unexpected reply", string(reply))
+			}
+		}
+
+		var alerts []alertMessage
+		reply := exchange(t, conn, `{"Latitude": 0, "Longitude": 0}`)
+		if err := json.Unmarshal(reply, &alerts); err != nil {
+			t.Fatal(subprotocols, "expected bare array, got", string(reply))
+		}
+		if len(alerts) != 1 || alerts[0].Identifier != "a" {
+			t.Error(subprotocols, "unexpected reply", string(reply))
+		}
+	}
+}
+
+// waitForDisconnect waits until p counted a disconnect for reason. Closing a connection to a client that doesn't read takes a few
+// seconds, since the proxy waits for the client to acknowledge.
+func waitForDisconnect(t *testing.T, p *Proxy, reason string) {
+	for i := 0; i < 500; i++ {
+		status := p.clientStatus()
+		if status.Disconnects[reason] == 1 && status.Connected == 0 {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("expected disconnect for %s, got %v", reason, p.clientStatus())
+}
+
+func TestDisconnectUnresponsiveClient(t *testing.T) {
+	defer func(interval, timeout time.Duration) {
+		_pingInterval, _pingTimeout = interval, timeout
+	}(_pingInterval, _pingTimeout)
+	_pingInterval, _pingTimeout = 50*time.Millisecond, 50*time.Millisecond
+
+	// The client never reads, so it doesn't answer pings
+	p := newProxy(nil, nil)
+	_, closeConn := dial(t, p)
+	defer closeConn()
+
+	waitForDisconnect(t, p, disconnectPingTimeout)
+}
+
+func TestDisconnectReadTimeout(t *testing.T) {
+	defer func(interval, timeout time.Duration) {
+		_pingInterval, _readTimeout = interval, timeout
+	}(_pingInterval, _readTimeout)
+	_pingInterval, _readTimeout = 0, 50*time.Millisecond
+
+	// The client only reads control frames, but never sends anything
+	p := newProxy(nil, nil)
+	conn, closeConn := dial(t, p)
+	defer closeConn()
+	conn.CloseRead(context.Background())
+
+	waitForDisconnect(t, p, disconnectReadTimeout)
+}
+
+func TestDisconnectClosed(t *testing.T) {
+	p := newProxy(nil, nil)
+	conn, closeConn := dial(t, p)
+	defer closeConn()
+
+	conn.Close(websocket.StatusNormalClosure, "")
+	waitForDisconnect(t, p, disconnectClosed)
+}
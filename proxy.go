@@ -2,38 +2,45 @@ package main
 
 // TODO: The big ticket items missing are:
 // - Persist data somehow?
-// - Expiry of messages with an expiry attribute
 
 import (
 	"encoding/json"
 	"errors"
 	"flag"
-	"io"
-	"io/ioutil"
 	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
-	"nhooyr.io/websocket"
-)
-
-const (
-	url1 = "https://warnung.bund.de/bbk.mowas/gefahrendurchsagen.json"
-	url2 = "https://warnung.bund.de/bbk.biwapp/warnmeldungen.json"
-	url3 = "https://warnung.bund.de/bbk.dwd/unwetter.json"
-
-	// TODO: Handle these as well, need to see them in action
-	url4 = "https://warnung.bund.de/bbk.lhp/hochwassermeldungen.json"
 )
 
 var (
-	_updateDelay time.Duration
-	_socketPath  string
-	_socketAddr  string
-	_logLevel    string
-	_logCallers  bool
+	_updateDelay   time.Duration
+	_socketPath    string
+	_socketAddr    string
+	_logLevel      string
+	_logCallers    bool
+	_sourceConfig  string
+	_sources       sourceFlags
+	_maxBackoff    time.Duration
+	_staleAfter    time.Duration
+	_statusPath    string
+	_boundaries    stringFlags
+	_maxAccuracy   float64
+	_maxLocations  int
+	_eventLogSize  int
+	_pingInterval  time.Duration
+	_pingTimeout   time.Duration
+	_readTimeout   time.Duration
+	_clientsPath   string
+	_sendQueueSize int
+	_writeTimeout  time.Duration
+	_slowClients   string
+	_allowOrigins  originPolicy
 )
 
 func init() {
@@ -42,6 +49,23 @@ func init() {
 	flag.StringVar(&_socketAddr, "socketAddr", ":8080", "Address to listen on for websocket connections")
 	flag.StringVar(&_logLevel, "logLevel", "info", "Log level to use")
 	flag.BoolVar(&_logCallers, "logCallers", false, "Whether to log callers")
+	flag.StringVar(&_sourceConfig, "sourceConfig", "", "Path to a JSON file listing the upstream sources to poll")
+	flag.Var(&_sources, "source", "Upstream source to poll as name=url, may be repeated")
+	flag.DurationVar(&_maxBackoff, "maxBackoff", 30*time.Minute, "Maximum delay between polling a failing source")
+	flag.DurationVar(&_staleAfter, "staleAfter", 15*time.Minute, "Time without a successful fetch after which a source is reported as stale")
+	flag.StringVar(&_statusPath, "statusPath", "/sources", "Path to the JSON source status")
+	flag.Var(&_boundaries, "boundaries", "GeoJSON file with boundaries to resolve geocodes, may be repeated")
+	flag.Float64Var(&_maxAccuracy, "maxAccuracy", 5000, "Maximum accuracy radius of client locations in meters, larger radii are capped")
+	flag.IntVar(&_maxLocations, "maxLocations", 10, "Maximum number of named locations per client")
+	flag.DurationVar(&_pingInterval, "pingInterval", 30*time.Second, "Interval between pings to clients, 0 disables pings")
+	flag.DurationVar(&_pingTimeout, "pingTimeout", 10*time.Second, "Time to wait for a client to answer a ping before disconnecting it")
+	flag.DurationVar(&_readTimeout, "readTimeout", 2*time.Minute, "Time without any message or pong from a client after which it is disconnected, 0 disables the timeout")
+	flag.StringVar(&_clientsPath, "clientsPath", "/clients", "Path to the JSON client statistics")
+	flag.IntVar(&_sendQueueSize, "sendQueueSize", 16, "Maximum number of messages queued for a client")
+	flag.DurationVar(&_writeTimeout, "writeTimeout", 10*time.Second, "Time a client may take to receive a message before it is disconnected")
+	flag.StringVar(&_slowClients, "slowClients", slowResync, "What to do if the send queue of a client is full: resync (drop all queued messages and send all matching alerts) or disconnect")
+	flag.IntVar(&_eventLogSize, "eventLogSize", 100, "Number of changes of the active alerts kept to replay them to reconnecting clients")
+	flag.Var((*stringFlags)(&_allowOrigins), "allowOrigin", "Origin of websites allowed to use the proxy besides its own, e.g. https://example.org or *.example.org, may be repeated")
 
 	logrus.SetFormatter(&logrus.TextFormatter{
 		DisableColors: true,
@@ -49,295 +73,322 @@ func init() {
 	})
 }
 
-// Client wraps a proxy reference and a logger for convenient access in the client's main loop
-type Client struct {
-	p   *Proxy
-	log atomic.Value
+// stringFlags collects the values of a repeated flag
+type stringFlags []string
+
+func (f *stringFlags) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(*f, ",")
+}
+
+func (f *stringFlags) Set(v string) error {
+	*f = append(*f, v)
+	return nil
 }
 
-func (c *Client) Log() *logrus.Entry {
-	return c.log.Load().(*logrus.Entry)
+type Proxy struct {
+	sync.Mutex // Protects updateChans and disconnects
+	sources    []Source
+	// state holds the current *snapshot of active alerts and their areas. It is replaced as a whole on updates, so readers never
+	// need to take a lock.
+	state       atomic.Value
+	updateChans map[chan bool]bool
+	// disconnects counts closed client connections by reason
+	disconnects map[string]uint64
+	// publishLock serializes updates of state by the per-source update loops
+	publishLock sync.Mutex
+	// boundaries resolves geocodes of alerts without polygons, may be nil
+	boundaries *boundaries
+	// history holds the last -eventLogSize snapshots with changed alerts, oldest first. Clients resuming a connection are sent the
+	// changes between them.
+	historyLock sync.Mutex
+	history     []*snapshot
+	// epoch identifies this run of the proxy, sequence numbers of other runs are unrelated
+	epoch string
+	// changed receives a value when the alerts change
+	changed chan struct{}
 }
 
-func (c *Client) SetLog(l *logrus.Entry) {
-	c.log.Store(l)
+func newProxy(sources []Source, bounds *boundaries) *Proxy {
+	p := &Proxy{
+		sources:     sources,
+		updateChans: make(map[chan bool]bool),
+		disconnects: make(map[string]uint64),
+		boundaries:  bounds,
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		changed:     make(chan struct{}, 1),
+	}
+	p.state.Store(newSnapshot())
+	return p
 }
 
-// getMatchingAlerts returns all alerts that have areas affecting the provided coordinate
-// This function is really fucking ugly.
-func (cl *Client) getMatchingAlerts(c Coordinate) []alertMessage {
-	p := cl.p
-	p.Lock()
-	defer p.Unlock()
+// snapshot returns the current state of active alerts. The result must not be modified.
+func (p *Proxy) snapshot() *snapshot {
+	return p.state.Load().(*snapshot)
+}
 
-	messageIDs := make(map[MessageID]bool)
-	cl.Log().WithField("numareas", len(p.areas)).Debug("checking area collections")
-	for id, areas := range p.areas {
-		cl.Log().WithField("numareas", len(areas)).Println("checking area(s)")
-		for _, area := range areas {
-			if area.Contains(c) {
-				messageIDs[id] = true
-			}
+// publish replaces the alerts of the source name with those of src, keeping the health of the source. It returns true if the
+// alerts of the source changed, i.e. alerts were added, removed or modified.
+func (p *Proxy) publish(name SourceName, src *sourceSnapshot) bool {
+	p.publishLock.Lock()
+	defer p.publishLock.Unlock()
+
+	current := p.snapshot()
+	changed := true
+	if old, ok := current.sources[name]; ok {
+		changed = !reflect.DeepEqual(old.alerts, src.alerts)
+		src.health = old.health
+	}
+
+	n := current.withSource(name, src)
+	if changed {
+		n.seq++
+		n.published = time.Now()
+		p.record(n)
+	}
+	p.state.Store(n)
+	if changed {
+		select {
+		case p.changed <- struct{}{}:
+		default:
+			// Already signalled
 		}
 	}
-	cl.Log().WithFields(logrus.Fields{
-		"count": len(messageIDs),
-		"ids":   messageIDs,
-	}).Debug("got matching message IDs")
-
-	// Create empty list. This doesn't use the `nil` pattern for new slices because those encode to `null` values in JSON.
-	matchingAlerts := make([]alertMessage, 0)
-	for id := range messageIDs {
-		// Gather all messages
-		for _, alerts := range p.activeAlerts {
-			if alert, ok := alerts[id]; ok {
-				matchingAlerts = append(matchingAlerts, alert)
-			}
+
+	return changed
+}
+
+// record adds s to the history of snapshots, dropping the oldest ones beyond -eventLogSize.
+func (p *Proxy) record(s *snapshot) {
+	p.historyLock.Lock()
+	defer p.historyLock.Unlock()
+
+	p.history = append(p.history, s)
+	if n := len(p.history) - _eventLogSize; n > 0 {
+		// Copy to release the dropped snapshots
+		p.history = append([]*snapshot(nil), p.history[n:]...)
+	}
+}
+
+// snapshotsSince returns the snapshot with sequence number seq and all later ones, oldest first. It returns nil if that snapshot is
+// no longer or not yet known.
+func (p *Proxy) snapshotsSince(seq uint64) []*snapshot {
+	p.historyLock.Lock()
+	defer p.historyLock.Unlock()
+
+	for i, s := range p.history {
+		if s.seq == seq {
+			return append([]*snapshot(nil), p.history[i:]...)
 		}
 	}
+	return nil
+}
+
+// setHealth replaces the health of the source name, keeping its alerts.
+func (p *Proxy) setHealth(name SourceName, h sourceHealth) {
+	p.publishLock.Lock()
+	defer p.publishLock.Unlock()
+
+	current := p.snapshot()
+	src := &sourceSnapshot{}
+	if old, ok := current.sources[name]; ok {
+		*src = *old
+	}
+	src.health = h
 
-	return matchingAlerts
+	p.state.Store(current.withSource(name, src))
 }
 
-type Proxy struct {
-	sync.Mutex
-	// Maps URLs to active messages, keyed by message ID
-	activeAlerts map[URL]map[MessageID]alertMessage
-	updateChans  map[chan bool]bool
-	areas        map[MessageID][]Area
+// sourceStatus returns the health of all sources in the order they were configured.
+func (p *Proxy) sourceStatus() []sourceStatus {
+	snap := p.snapshot()
+	now := time.Now()
+
+	status := make([]sourceStatus, 0, len(p.sources))
+	for _, src := range p.sources {
+		var h sourceHealth
+		if s, ok := snap.sources[src.Name]; ok {
+			h = s.health
+		}
+		status = append(status, newSourceStatus(src.Name, h, now, _staleAfter))
+	}
+	return status
 }
 
-func newProxy() Proxy {
-	return Proxy{
-		activeAlerts: make(map[URL]map[MessageID]alertMessage),
-		updateChans:  make(map[chan bool]bool),
-		areas:        make(map[MessageID][]Area),
+// statusHandler serves the health of all sources as JSON, so clients can tell their users when warnings may be outdated.
+func (p *Proxy) statusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(p.sourceStatus())
+	if err != nil {
+		logrus.WithField("component", "status").Error("Failed to write source status:", err)
 	}
 }
 
-func (p *Proxy) registerUpdateChan(ch chan bool) {
+// clientStatus are statistics about the clients of the proxy
+type clientStatus struct {
+	Connected   int               `json:"connected"`
+	Disconnects map[string]uint64 `json:"disconnects"` // Closed connections by reason
+}
+
+// countDisconnect counts a closed client connection.
+func (p *Proxy) countDisconnect(reason string) {
 	p.Lock()
 	defer p.Unlock()
 
-	p.updateChans[ch] = true
+	p.disconnects[reason]++
 }
 
-func (p *Proxy) unregisterUpdateChan(ch chan bool) {
+// clientStatus returns the current client statistics.
+func (p *Proxy) clientStatus() clientStatus {
 	p.Lock()
 	defer p.Unlock()
 
-	delete(p.updateChans, ch)
-}
-
-// socketHandler runs a client connection
-func (p *Proxy) socketHandler(w http.ResponseWriter, r *http.Request) {
-	client := Client{
-		p: p,
+	status := clientStatus{
+		Connected:   len(p.updateChans),
+		Disconnects: make(map[string]uint64, len(p.disconnects)),
+	}
+	for reason, n := range p.disconnects {
+		status.Disconnects[reason] = n
 	}
-	client.SetLog(logrus.WithFields(logrus.Fields{
-		"component": "client",
-		"remote":    r.RemoteAddr,
-	}))
+	return status
+}
 
-	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+// clientsHandler serves the client statistics as JSON.
+func (p *Proxy) clientsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(p.clientStatus())
 	if err != nil {
-		client.Log().Error("Failed to set up websocket:", err)
-		return
+		logrus.WithField("component", "status").Error("Failed to write client status:", err)
 	}
-	defer conn.Close(websocket.StatusInternalError, "internal server error")
-
-	// When a user sends a Lat/Lon pair, check active alerts on p
-	// Otherwise, watch the active alerts for new things
-	quit := make(chan interface{})
-	done := make(chan interface{})
-	coords := make(chan Coordinate)
-
-	updateChan := make(chan bool)
-	p.registerUpdateChan(updateChan)
-	defer p.unregisterUpdateChan(updateChan)
-
-	// First part, run a goroutine to await changes on the active alerts
-	go func() {
-		var currentCoords Coordinate
-		coordsSet := false // True if coordinates have been received
-		running := true
-
-		client.Log().Info("Waiting for changes in active alerts or coordinates")
-		for running {
-			writer, err := conn.Writer(r.Context(), websocket.MessageText)
-			if err != nil {
-				client.Log().Error("can't set up writer:", err)
-				return
-			}
-			enc := json.NewEncoder(writer)
-
-			select {
-			case c := <-coords:
-				currentCoords = c
-				coordsSet = true
-				client.SetLog(client.Log().WithField("coordinate", c))
-				client.Log().Info("Received new coordinate")
-				alerts := client.getMatchingAlerts(c)
-				enc.Encode(&alerts)
-			case <-updateChan:
-				if coordsSet {
-					alerts := client.getMatchingAlerts(currentCoords)
-					enc.Encode(&alerts)
-				} else {
-					client.Log().Info("not checking update, no coords set")
-				}
-			case <-quit:
-				running = false
-			}
-			writer.Close()
-		}
-		client.Log().Info("Active alert watcher quitting")
-		close(done)
-	}()
+}
 
-	// Consume from the websocket tow gather new lat/lon pairs, exit on first error
-	for {
-		mt, reader, err := conn.Reader(r.Context())
-		if err != nil {
-			client.Log().Error("Failed to read from websocket:", err)
-			break
-		}
-		if mt != websocket.MessageText {
-			// Consume all non-text message and drop them
-			client.Log().Debug("Non-text message received")
-			for {
-				buf := make([]byte, 32)
-				_, err := reader.Read(buf)
-				if err != nil {
-					client.Log().Error("Failed to read from websocket:", err)
-					break
-				}
-				client.Log().WithField("part", buf).Debug("message part consumed")
-			}
-			continue
-		}
+func (p *Proxy) registerUpdateChan(ch chan bool) {
+	p.Lock()
+	defer p.Unlock()
 
-		for {
-			dec := json.NewDecoder(reader)
-			var coord Coordinate
-			err = dec.Decode(&coord)
-			if err != nil {
-				if errors.Is(err, io.EOF) {
-					client.Log().Error("Error while decoding:", err)
-				}
-				break
-			}
-
-			// Update coordinates and re-check active alerts
-			coords <- coord
-		}
-	}
+	p.updateChans[ch] = true
+}
+
+func (p *Proxy) unregisterUpdateChan(ch chan bool) {
+	p.Lock()
+	defer p.Unlock()
 
-	// Signal goroutine that it's time to go
-	close(quit)
-	// ... and wait for it to exit
-	<-done
-	conn.Close(websocket.StatusNormalClosure, "")
+	delete(p.updateChans, ch)
 }
 
-// updateData requests new data from url and updates the stored alert messages. It returns true if an update was performed, and
-// false if no new data arrived
+// updateData requests new data from the source of f and publishes a new snapshot of the stored alert messages. It returns true
+// if an update was performed, and false if no new data arrived
 //
-// It requires p to be locked.
-func (p *Proxy) updateData(url URL) (bool, error) {
-	if _, ok := p.activeAlerts[url]; !ok {
-		p.activeAlerts[url] = make(map[MessageID]alertMessage)
+// Fetching and parsing happen without holding any lock, clients keep matching against the previous snapshot in the meantime. If
+// fetching or decoding fails, the previous snapshot of the source stays active as a whole. Incomplete content is published, but
+// still reported as an error.
+func (p *Proxy) updateData(f *fetcher, log *logrus.Entry) (bool, error) {
+	content, err := f.fetch()
+	if errors.Is(err, errNotModified) {
+		return false, nil
 	}
-
-	resp, err := http.Get(string(url))
 	if err != nil {
 		return false, err
 	}
-	defer resp.Body.Close()
 
-	content, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	alerts, err := f.decode(content)
+	incomplete := errors.Is(err, errIncomplete)
+	if err != nil && !incomplete {
 		return false, err
 	}
 
-	var alerts []alertMessage
-	err = json.Unmarshal(content, &alerts)
-	if err != nil {
-		return false, err
+	snap := newSourceSnapshot(f.src.Name, alerts, p.boundaries, log)
+
+	changed := p.publish(f.src.Name, snap)
+	if incomplete {
+		// Publish what we have, but don't commit so the content is processed again
+		return changed, err
 	}
+	f.commit()
 
-	// Track new alerts
-	newAlerts := 0
+	return changed, nil
+}
 
-	for _, alert := range alerts {
-		// Update messages for this url
-		if _, ok := p.activeAlerts[url][alert.Identifier]; !ok {
-			// This message is new, track it.
-			newAlerts++
+// notifyClients signals all connected clients that the active alerts changed.
+func (p *Proxy) notifyClients() {
+	p.Lock()
+	defer p.Unlock()
+
+	// Non-blocking notify to make sure slow clients don't block us. The channels are buffered, so a client busy with the previous
+	// update gets this one once it's done.
+	for ch := range p.updateChans {
+		select {
+		case ch <- true:
+		default:
 		}
 	}
+}
 
-	// Set new active message map
-	p.activeAlerts[url] = make(map[MessageID]alertMessage)
-
-	for _, message := range alerts {
-		p.activeAlerts[url][message.Identifier] = message
-		// Collect all areas for this message
-		var areas []Area
-		for _, info := range message.Info {
-			for _, area := range info.Area {
-				for _, poly := range area.Polygon {
-					a, err := NewAreaFromString(poly)
-					if err != nil {
-						return false, err
-					}
-					areas = append(areas, a)
-				}
-			}
+// expiryLoop notifies all connected clients whenever an alert expires. Feeds usually keep serving expired alerts until they are
+// replaced, so without this, clients would only learn about expired alerts with the next unrelated change.
+func (p *Proxy) expiryLoop() {
+	log := logrus.WithField("component", "expiry")
+
+	for {
+		var timer *time.Timer
+		var expiry <-chan time.Time
+		if next := p.snapshot().nextExpiry(time.Now()); !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			expiry = timer.C
+			log.WithField("next", next).Debug("waiting for next expiry")
 		}
-		p.areas[message.Identifier] = areas
-	}
 
-	return newAlerts != 0, nil
+		select {
+		case <-p.changed:
+		case <-expiry:
+			log.Info("Notifying connected clients of expired alerts")
+			p.notifyClients()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
 }
 
-// updateLoop polls all URLs and updates the proxy state. On update, it checks subscribed customers for area containment and notify
-// them.
-func (p *Proxy) updateLoop() {
-	log := logrus.WithField("component", "updater")
-
-	urls := []URL{url1, url2, url3, url4}
+// updateLoop polls src and updates the proxy state. On update, it checks subscribed customers for area containment and notify
+// them. Failing sources are polled with an exponential backoff.
+func (p *Proxy) updateLoop(src Source) {
+	log := logrus.WithFields(logrus.Fields{
+		"component": "updater",
+		"source":    src.Name,
+	})
 
-	ticker := time.NewTicker(_updateDelay)
+	f := newFetcher(src, log)
+	var health sourceHealth
 
 	for {
-		needUpdate := false
-		p.Lock()
-		p.areas = make(map[MessageID][]Area)
-		for _, url := range urls {
-			newData, err := p.updateData(url)
-			if err != nil {
-				log.WithFields(logrus.Fields{
-					"url": url,
-					"err": err}).Error("update failed")
-				continue
-			}
-			log.WithField("url", url).Debug("data refreshed")
-			needUpdate = needUpdate || newData
+		newData, err := p.updateData(f, log)
+		if errors.Is(err, errIncomplete) {
+			// The decoder retries the missing parts on its own, the rest of the source is fine
+			health.incomplete(time.Now(), err)
+			log.WithFields(logrus.Fields{
+				"url": src.URL,
+				"err": err}).Warn("update incomplete")
+		} else if err != nil {
+			health.failed(time.Now(), err)
+			log.WithFields(logrus.Fields{
+				"url":      src.URL,
+				"failures": health.Failures,
+				"err":      err}).Error("update failed")
+		} else {
+			health.succeeded(time.Now())
+			log.WithField("url", src.URL).Debug("data refreshed")
 		}
-		if needUpdate {
+		p.setHealth(src.Name, health)
+		if newData {
 			log.Info("Notifying connected clients of updates")
-			// Non-blocking notify to make sure slow clients don't block us
-			for ch := range p.updateChans {
-				select {
-				case ch <- true:
-				default:
-				}
-			}
+			p.notifyClients()
 		}
-		p.Unlock()
-		log.WithField("delay", _updateDelay).Debug("waiting for next update")
-		<-ticker.C
+		delay := health.backoff(src.interval(), _maxBackoff)
+		log.WithField("delay", delay).Debug("waiting for next update")
+		time.Sleep(delay)
 	}
 }
 
@@ -353,11 +404,54 @@ func main() {
 
 	logrus.Info("Starting up")
 
-	proxy := newProxy()
+	if err := validateSlowClientPolicy(_slowClients); err != nil {
+		logrus.Fatalln("Invalid -slowClients:", err)
+	}
+	if _sendQueueSize < 1 {
+		logrus.Fatalln("Invalid -sendQueueSize: must be at least 1, got", _sendQueueSize)
+	}
+	if _eventLogSize < 0 {
+		logrus.Fatalln("Invalid -eventLogSize: must not be negative, got", _eventLogSize)
+	}
+	if err := _allowOrigins.validate(); err != nil {
+		logrus.Fatalln("Invalid -allowOrigin:", err)
+	}
 
-	go proxy.updateLoop()
+	sources, err := loadSources(_sourceConfig, _sources)
+	if err != nil {
+		logrus.Fatalln("Can't load sources:", err)
+	}
+	if len(sources) == 0 {
+		logrus.Fatalln("No enabled sources configured")
+	}
+
+	bounds, n, err := loadBoundaries(_boundaries)
+	if err != nil {
+		logrus.Fatalln("Can't load boundaries:", err)
+	}
+	logrus.WithField("regions", n).Info("Boundaries loaded")
+	for _, src := range sources {
+		if src.Format == formatLHP && n == 0 {
+			logrus.WithField("source", src.Name).Warn("No -boundaries loaded, flood warnings for whole states won't match any client")
+		}
+	}
+
+	proxy := newProxy(sources, bounds)
+
+	for _, src := range proxy.sources {
+		logrus.WithFields(logrus.Fields{
+			"source":   src.Name,
+			"url":      src.URL,
+			"format":   src.Format,
+			"interval": src.interval(),
+		}).Info("Polling source")
+		go proxy.updateLoop(src)
+	}
+	go proxy.expiryLoop()
 
 	http.HandleFunc(_socketPath, proxy.socketHandler)
+	http.HandleFunc(_statusPath, _allowOrigins.cors(proxy.statusHandler))
+	http.HandleFunc(_clientsPath, _allowOrigins.cors(proxy.clientsHandler))
 	http.Handle("/", http.FileServer(http.Dir("static")))
 
 	logrus.Info("Handlers configured, app started")
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// This file contains the outbound queue of websocket clients.
+
+// Policies for clients not reading their messages fast enough
+const (
+	// slowResync drops all queued messages and sends an alerts event with the current state instead
+	slowResync = "resync"
+	// slowDisconnect disconnects the client, it may resume the connection later
+	slowDisconnect = "disconnect"
+)
+
+func validateSlowClientPolicy(policy string) error {
+	switch policy {
+	case slowResync, slowDisconnect:
+		return nil
+	}
+	return fmt.Errorf("unknown slow client policy %q, must be %q or %q", policy, slowResync, slowDisconnect)
+}
+
+// sendQueue is a bounded queue of messages to a client. It decouples computing events from writing them, so a slow client doesn't
+// delay the processing of its commands and updates.
+type sendQueue struct {
+	sync.Mutex // Protects messages
+	messages   []interface{}
+	size       int
+	// ready receives a value when messages are pushed
+	ready chan struct{}
+}
+
+func newSendQueue(size int) *sendQueue {
+	return &sendQueue{
+		size:  size,
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// push appends messages to the queue. It returns false and queues nothing if they don't fit.
+func (q *sendQueue) push(messages ...interface{}) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.messages)+len(messages) > q.size {
+		return false
+	}
+	q.messages = append(q.messages, messages...)
+	q.signal()
+	return true
+}
+
+// replace drops all queued messages and queues messages instead. It returns the number of dropped messages.
+func (q *sendQueue) replace(messages ...interface{}) int {
+	q.Lock()
+	defer q.Unlock()
+
+	dropped := len(q.messages)
+	q.messages = append([]interface{}(nil), messages...)
+	q.signal()
+	return dropped
+}
+
+// pop removes and returns the oldest message. ok is false if the queue is empty.
+func (q *sendQueue) pop() (msg interface{}, ok bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.messages) == 0 {
+		return nil, false
+	}
+	msg = q.messages[0]
+	q.messages[0] = nil
+	q.messages = q.messages[1:]
+	return msg, true
+}
+
+// signal notifies the consumer of new messages without blocking. q must be locked.
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+		// Already signalled
+	}
+}
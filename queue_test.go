@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestSendQueue(t *testing.T) {
+	q := newSendQueue(3)
+	if !q.push(1, 2) {
+		t.Fatal("expected messages to fit")
+	}
+	if q.push(3, 4) {
+		t.Error("expected messages not to fit")
+	}
+	if msg, ok := q.pop(); !ok || msg != 1 {
+		t.Error("expected oldest message, got", msg)
+	}
+	if !q.push(3, 4) {
+		t.Error("expected messages to fit after pop")
+	}
+
+	if dropped := q.replace(5); dropped != 3 {
+		t.Error("expected 3 dropped messages, got", dropped)
+	}
+	if msg, ok := q.pop(); !ok || msg != 5 {
+		t.Error("expected replacement, got", msg)
+	}
+	if _, ok := q.pop(); ok {
+		t.Error("expected empty queue")
+	}
+
+	select {
+	case <-q.ready:
+	default:
+		t.Error("expected queue to be ready")
+	}
+}
+
+func TestEnqueueSlowClient(t *testing.T) {
+	defer func(policy string) { _slowClients = policy }(_slowClients)
+
+	c := testClient(testAlert("a", _testArea2))
+	c.cancel = func() {}
+	c.locations["home"] = Location{}
+	q := newSendQueue(2)
+	events := []interface{}{c.alerts(), c.alerts()}
+
+	_slowClients = slowResync
+	if !c.enqueue(q, events) || !c.enqueue(q, events) {
+		t.Fatal("expected client to stay connected")
+	}
+	msg, _ := q.pop()
+	if e, ok := msg.(alertsEvent); !ok || e.Type != eventAlerts || len(e.Alerts) != 1 {
+		t.Error("expected all alerts after dropping, got", msg)
+	}
+	if _, ok := q.pop(); ok {
+		t.Error("expected queued events to be dropped")
+	}
+
+	_slowClients = slowDisconnect
+	q.push(1, 2)
+	if c.enqueue(q, events) || c.reason != disconnectSlow {
+		t.Error("expected slow client to be disconnected, got", c.reason)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// This file contains the immutable snapshot of active alerts that clients match their coordinates against.
+
+// sourceSnapshot holds the active alerts of a single source together with their parsed areas and the health of the source. It must
+// not be modified once it has been published.
+type sourceSnapshot struct {
+	alerts map[MessageID]alertMessage
+	areas  map[MessageID][]Shape
+	index  *gridIndex // Spatial index of areas
+	health sourceHealth
+}
+
+// newSourceSnapshot parses the areas of alerts and returns a snapshot for src. Polygons and circles that fail to parse are logged to
+// log and skipped, so a single malformed polygon doesn't prevent the rest of the feed from being used. Areas without polygons and
+// circles are resolved by their geocodes using bounds, which may be nil.
+func newSourceSnapshot(src SourceName, alerts []alertMessage, bounds *boundaries, log *logrus.Entry) *sourceSnapshot {
+	s := &sourceSnapshot{
+		alerts: make(map[MessageID]alertMessage),
+		areas:  make(map[MessageID][]Shape),
+	}
+
+	for _, message := range alerts {
+		message.Feed = src
+		s.alerts[message.Identifier] = message
+		// Collect all areas for this message
+		var shapes []Shape
+		for _, info := range message.Info {
+			for _, area := range info.Area {
+				shapes = append(shapes, parseArea(area, message.Identifier, bounds, log)...)
+			}
+		}
+		s.areas[message.Identifier] = shapes
+	}
+	s.index = newGridIndex(s.areas)
+
+	return s
+}
+
+// geocodeExcludePolygon names geocodes holding a polygon that is excluded from the polygons of the area, as used by the DWD
+const geocodeExcludePolygon = "EXCLUDE_POLYGON"
+
+// parseArea returns the shapes of a CAP area. Like CAP, the area is the union of its polygons and circles, only polygons given as
+// EXCLUDE_POLYGON geocodes are cut out of the polygons.
+func parseArea(area areaDescription, id MessageID, bounds *boundaries, log *logrus.Entry) []Shape {
+	log = log.WithFields(logrus.Fields{
+		"id":   id,
+		"area": area.Description,
+	})
+
+	var polygons []Area
+	for _, poly := range area.Polygon {
+		a, err := NewAreaFromString(poly)
+		if err != nil {
+			log.WithField("err", err).Warn("skipping malformed polygon")
+			continue
+		}
+		polygons = append(polygons, a)
+	}
+	var excluded []Area
+	for _, g := range area.Geocode {
+		if !strings.EqualFold(g.ValueName, geocodeExcludePolygon) {
+			continue
+		}
+		a, err := NewAreaFromString(g.Value)
+		if err != nil {
+			log.WithField("err", err).Warn("skipping malformed excluded polygon")
+			continue
+		}
+		excluded = append(excluded, a)
+	}
+
+	var shapes []Shape
+	for _, a := range excludeAreas(polygons, excluded) {
+		shapes = append(shapes, a)
+	}
+	for _, circle := range area.Circle {
+		c, err := NewCircleFromString(circle)
+		if err != nil {
+			log.WithField("err", err).Warn("skipping malformed circle")
+			continue
+		}
+		shapes = append(shapes, c)
+	}
+
+	if len(area.Polygon) == 0 && len(area.Circle) == 0 {
+		for _, a := range resolveGeocodes(area, bounds, log) {
+			shapes = append(shapes, a)
+		}
+	}
+	return shapes
+}
+
+// resolveGeocodes returns the areas of all geocodes of area that are known to bounds.
+func resolveGeocodes(area areaDescription, bounds *boundaries, log *logrus.Entry) []Area {
+	var areas []Area
+	for _, geocode := range area.Geocode {
+		resolved := bounds.resolve(geocode)
+		if resolved == nil {
+			log.WithField("geocode", geocode).Debug("unknown geocode")
+			continue
+		}
+		areas = append(areas, resolved...)
+	}
+	return areas
+}
+
+// snapshot is the state of all sources at one point in time. Like sourceSnapshot, it must not be modified once it has been
+// published, updates create a new snapshot with withSource.
+type snapshot struct {
+	sources map[SourceName]*sourceSnapshot
+	// retracted contains the IDs of messages that were cancelled or superseded by an update in any source
+	retracted map[MessageID]bool
+	// seq is the sequence number of the last change of the alerts, 0 before any alerts were published
+	seq uint64
+	// published is the time of the last change of the alerts
+	published time.Time
+}
+
+func newSnapshot() *snapshot {
+	return &snapshot{
+		sources:   make(map[SourceName]*sourceSnapshot),
+		retracted: make(map[MessageID]bool),
+	}
+}
+
+// withSource returns a copy of s in which the state of the source name is replaced by src.
+func (s *snapshot) withSource(name SourceName, src *sourceSnapshot) *snapshot {
+	n := newSnapshot()
+	n.seq = s.seq
+	n.published = s.published
+	for k, v := range s.sources {
+		n.sources[k] = v
+	}
+	n.sources[name] = src
+
+	for _, src := range n.sources {
+		for id, alert := range src.alerts {
+			if alert.MsgType != msgTypeUpdate && alert.MsgType != msgTypeCancel {
+				continue
+			}
+			for _, ref := range alert.referencedIDs() {
+				if ref != id {
+					n.retracted[ref] = true
+				}
+			}
+		}
+	}
+
+	return n
+}
+
+// deliverable returns true if alert should be delivered to clients at now. Cancel messages are never delivered, they only retract
+// the messages they reference.
+func (s *snapshot) deliverable(alert alertMessage, now time.Time) bool {
+	return alert.MsgType != msgTypeCancel && !s.retracted[alert.Identifier] && !alert.expired(now)
+}
+
+// nextExpiry returns the time at which the next alert deliverable at now expires. It returns the zero time if none of them expire.
+func (s *snapshot) nextExpiry(now time.Time) time.Time {
+	var next time.Time
+	for _, src := range s.sources {
+		for _, alert := range src.alerts {
+			if !s.deliverable(alert, now) {
+				continue
+			}
+			if t := alert.expires(); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+	}
+	return next
+}
+
+// matchingAlerts returns all alerts that have areas within radius km of c and are deliverable at now.
+func (s *snapshot) matchingAlerts(c Coordinate, radius float64, now time.Time) []alertMessage {
+	return s.lookup(now, func(g *gridIndex) []MessageID {
+		return g.near(c, radius)
+	})
+}
+
+// intersectingAlerts returns all alerts that have areas intersecting a and are deliverable at now.
+func (s *snapshot) intersectingAlerts(a Area, now time.Time) []alertMessage {
+	return s.lookup(now, func(g *gridIndex) []MessageID {
+		return g.intersecting(a)
+	})
+}
+
+// lookup returns the alerts of all sources found by query that are deliverable at now.
+func (s *snapshot) lookup(now time.Time, query func(g *gridIndex) []MessageID) []alertMessage {
+	// Create empty list. This doesn't use the `nil` pattern for new slices because those encode to `null` values in JSON.
+	matchingAlerts := make([]alertMessage, 0)
+	for _, src := range s.sources {
+		if src.index == nil {
+			// Source without any successful fetch yet
+			continue
+		}
+		for _, id := range query(src.index) {
+			alert := src.alerts[id]
+			if s.deliverable(alert, now) {
+				matchingAlerts = append(matchingAlerts, alert)
+			}
+		}
+	}
+	return matchingAlerts
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var testLog = logrus.WithField("component", "test")
+
+func testAlert(id MessageID, polygon string) alertMessage {
+	return alertMessage{
+		Identifier: id,
+		Info: []infoItem{
+			{Area: []areaDescription{{Polygon: []string{polygon}}}},
+		},
+	}
+}
+
+func TestSnapshotWithSource(t *testing.T) {
+	src := newSourceSnapshot("test", []alertMessage{testAlert("a", _testArea2)}, nil, testLog)
+
+	s1 := newSnapshot()
+	s2 := s1.withSource("test", src)
+
+	if len(s1.sources) != 0 {
+		t.Error("withSource modified the original snapshot:", s1.sources)
+	}
+	if alerts := s1.matchingAlerts(Coordinate{0, 0}, 0, time.Now()); len(alerts) != 0 {
+		t.Error("unexpected alerts in empty snapshot:", alerts)
+	}
+
+	alerts := s2.matchingAlerts(Coordinate{0, 0}, 0, time.Now())
+	if len(alerts) != 1 {
+		t.Fatal("expected one matching alert, got", alerts)
+	}
+	if alerts[0].Feed != "test" {
+		t.Errorf("expected source to be set to test, got %q", alerts[0].Feed)
+	}
+	if alerts := s2.matchingAlerts(Coordinate{0, -3}, 0, time.Now()); len(alerts) != 0 {
+		t.Error("unexpected alerts outside of area:", alerts)
+	}
+}
+
+func TestPublishDetectsChanges(t *testing.T) {
+	p := newProxy(nil, nil)
+
+	src := newSourceSnapshot("test", []alertMessage{testAlert("a", _testArea2)}, nil, testLog)
+	if !p.publish("test", src) {
+		t.Error("expected first publish to report changes")
+	}
+	src = newSourceSnapshot("test", []alertMessage{testAlert("a", _testArea2)}, nil, testLog)
+	if p.publish("test", src) {
+		t.Error("expected publish of the same alerts to report no changes")
+	}
+	src = newSourceSnapshot("test", []alertMessage{testAlert("a", _testArea2), testAlert("b", _testArea2)}, nil, testLog)
+	if !p.publish("test", src) {
+		t.Error("expected publish with an additional alert to report new alerts")
+	}
+	src = newSourceSnapshot("test", []alertMessage{testAlert("b", _testArea2)}, nil, testLog)
+	if !p.publish("test", src) {
+		t.Error("expected publish with a removed alert to report changes")
+	}
+	modified := testAlert("b", _testArea2)
+	modified.Info[0].Headline = "changed"
+	src = newSourceSnapshot("test", []alertMessage{modified}, nil, testLog)
+	if !p.publish("test", src) {
+		t.Error("expected publish with a modified alert to report changes")
+	}
+}
+
+func TestSourceSnapshotSkipsMalformedPolygons(t *testing.T) {
+	alert := testAlert("a", "1,2 foo")
+	alert.Info[0].Area = append(alert.Info[0].Area, areaDescription{Polygon: []string{_testArea2}})
+	src := newSourceSnapshot("test", []alertMessage{alert, testAlert("b", "bar")}, nil, testLog)
+
+	if len(src.alerts) != 2 {
+		t.Error("expected both alerts to be kept, got", src.alerts)
+	}
+	if len(src.areas["a"]) != 1 {
+		t.Error("expected the valid area of a to be kept, got", src.areas["a"])
+	}
+	if len(src.areas["b"]) != 0 {
+		t.Error("expected no areas for b, got", src.areas["b"])
+	}
+}
+
+func TestUpdateDataKeepsLastKnownGood(t *testing.T) {
+	body := `[{"identifier": "a", "info": [{"area": [{"polygon": ["-1,-1 1,-1 1,1 -1,1 -1,-1"]}]}]}]`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	p := newProxy(nil, nil)
+	f := newFetcher(Source{Name: "test", URL: URL(srv.URL), Format: formatBBKJSON}, testLog)
+
+	if _, err := p.updateData(f, testLog); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	body = `[{"identifier": "a", "info": [{"area": [{"polygon": [`
+	if _, err := p.updateData(f, testLog); err == nil {
+		t.Fatal("expected error for truncated feed")
+	}
+
+	alerts := p.snapshot().matchingAlerts(Coordinate{0, 0}, 0, time.Now())
+	if len(alerts) != 1 || alerts[0].Identifier != "a" {
+		t.Error("expected last known good alert to still match, got", alerts)
+	}
+}
+
+func TestSnapshotRetractsAndExpires(t *testing.T) {
+	now := time.Now()
+
+	expired := testAlert("expired", _testArea2)
+	expired.Info[0].Expires = now.Add(-time.Minute)
+	current := testAlert("current", _testArea2)
+	current.Info[0].Expires = now.Add(time.Minute)
+	cancelled := testAlert("cancelled", _testArea2)
+	cancel := testAlert("cancel", _testArea2)
+	cancel.MsgType = msgTypeCancel
+	cancel.References = "sender@example.com,cancelled,2020-01-22T16:05:31+01:00"
+	superseded := testAlert("superseded", _testArea2)
+	update := testAlert("update", _testArea2)
+	update.MsgType = msgTypeUpdate
+	update.References = "sender@example.com,superseded,2020-01-22T16:05:31+01:00"
+
+	src := newSourceSnapshot("test", []alertMessage{expired, current, cancelled, cancel, superseded, update}, nil, testLog)
+	// Updates also apply across sources
+	other := newSourceSnapshot("other", []alertMessage{testAlert("superseded", _testArea2)}, nil, testLog)
+	s := newSnapshot().withSource("other", other).withSource("test", src)
+
+	ids := make(map[MessageID]bool)
+	for _, alert := range s.matchingAlerts(Coordinate{0, 0}, 0, now) {
+		ids[alert.Identifier] = true
+	}
+	if len(ids) != 2 || !ids["current"] || !ids["update"] {
+		t.Error("expected only current and update to match, got", ids)
+	}
+}
+
+func TestParseAreaUnion(t *testing.T) {
+	testCases := []struct {
+		area     areaDescription
+		expected []testCase
+	}{
+		{
+			// CAP areas are the union of their polygons, even if one lies inside another one
+			areaDescription{
+				Description: "Landkreis und Stadt",
+				Polygon:     []string{"0,0 10,0 10,10 0,10 0,0", "2,2 4,2 4,4 2,4 2,2"},
+			},
+			[]testCase{
+				{true, Coordinate{1, 1}},   // County
+				{true, Coordinate{3, 3}},   // City
+				{false, Coordinate{0, 12}}, // Outside
+			},
+		},
+		{
+			areaDescription{
+				Description: "Landkreis ohne Stadt, Umkreis",
+				Polygon:     []string{"0,0 10,0 10,10 0,10 0,0"},
+				Circle:      []string{"5,5 50", "foo"},
+				Geocode:     []valuePair{{"EXCLUDE_POLYGON", "2,2 8,2 8,8 2,8 2,2"}, {"exclude_polygon", "bar"}},
+			},
+			[]testCase{
+				{true, Coordinate{1, 1}},   // County
+				{true, Coordinate{5, 5}},   // Excluded city, but within circle
+				{false, Coordinate{7, 7}},  // Excluded city, outside of circle
+				{false, Coordinate{0, 12}}, // Outside
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		alert := alertMessage{Identifier: "test", Info: []infoItem{{Area: []areaDescription{tc.area}}}}
+		s := newSnapshot().withSource("test", newSourceSnapshot("test", []alertMessage{alert}, nil, testLog))
+
+		for _, testCase := range tc.expected {
+			is := len(s.matchingAlerts(testCase.c, 0, time.Now())) == 1
+			if is != testCase.expected {
+				t.Error(tc.area.Description, "expected:", testCase.c, "=", testCase.expected, "got:", is)
+			}
+		}
+	}
+}
+
+func TestMatchingAlertsAccuracy(t *testing.T) {
+	// About 300m north of the square
+	c := Coordinate{1.0027, 0}
+	s := newSnapshot().withSource("test", newSourceSnapshot("test", []alertMessage{testAlert("a", _testArea2)}, nil, testLog))
+
+	if alerts := s.matchingAlerts(c, 0, time.Now()); len(alerts) != 0 {
+		t.Error("expected no alerts without accuracy, got", alerts)
+	}
+	if alerts := s.matchingAlerts(c, 0.5, time.Now()); len(alerts) != 1 {
+		t.Error("expected alert within accuracy radius, got", alerts)
+	}
+}
+
+func TestLocationRadius(t *testing.T) {
+	var l Location
+	if err := json.Unmarshal([]byte(`{"Latitude": 48.8345, "Longitude": 8.3819, "Accuracy": 300}`), &l); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if l.Coordinate != (Coordinate{48.8345, 8.3819}) || l.radius() != 0.3 {
+		t.Error("unexpected location", l, l.radius())
+	}
+
+	testCases := []struct {
+		accuracy, radius float64
+	}{
+		{0, 0},
+		{-5, 0},
+		{math.NaN(), 0},
+		{1e9, _maxAccuracy / 1000},
+	}
+	for _, testCase := range testCases {
+		if r := (Location{Accuracy: testCase.accuracy}).radius(); r != testCase.radius {
+			t.Errorf("accuracy %f: expected radius %f, got %f", testCase.accuracy, testCase.radius, r)
+		}
+	}
+}
+
+func TestSnapshotNextExpiry(t *testing.T) {
+	now := time.Now()
+	expiring := func(id MessageID, expires ...time.Time) alertMessage {
+		a := alertMessage{Identifier: id}
+		for _, e := range expires {
+			a.Info = append(a.Info, infoItem{Expires: e})
+		}
+		return a
+	}
+	cancel := expiring("cancel", now.Add(time.Minute))
+	cancel.MsgType = msgTypeCancel
+	cancel.References = "retracted"
+
+	s := newSnapshot().withSource("test", newSourceSnapshot("test", []alertMessage{
+		expiring("expired", now.Add(-time.Minute)),
+		expiring("never", time.Time{}),
+		expiring("partly", now.Add(-time.Hour), now.Add(3*time.Hour)),
+		expiring("later", now.Add(2*time.Hour)),
+		expiring("retracted", now.Add(time.Minute)),
+		cancel,
+	}, nil, testLog))
+	if next := s.nextExpiry(now); !next.Equal(now.Add(2 * time.Hour)) {
+		t.Error("unexpected next expiry", next)
+	}
+	if next := newSnapshot().nextExpiry(now); !next.IsZero() {
+		t.Error("expected no expiry without alerts, got", next)
+	}
+}
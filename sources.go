@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// This file contains the registry of upstream feeds polled by the proxy.
+
+// SourceName identifies an upstream feed. It is used to key active alerts and shows up in logs and client payloads.
+type SourceName string
+
+const (
+	// formatBBKJSON is the JSON rendering of CAP messages served by warnung.bund.de
+	formatBBKJSON = "bbk-json"
+)
+
+// defaultSources are polled if neither a source config file nor -source flags are given.
+var defaultSources = []Source{
+	{Name: "mowas", URL: "https://warnung.bund.de/bbk.mowas/gefahrendurchsagen.json", Format: formatBBKJSON, Enabled: true},
+	{Name: "biwapp", URL: "https://warnung.bund.de/bbk.biwapp/warnmeldungen.json", Format: formatBBKJSON, Enabled: true},
+	{Name: "dwd", URL: "https://warnung.bund.de/bbk.dwd/unwetter.json", Format: formatBBKJSON, Enabled: true},
+	{Name: "lhp", URL: "https://warnung.bund.de/bbk.lhp/hochwassermeldungen.json", Format: formatLHP, Enabled: true},
+}
+
+// Duration is a time.Duration that is encoded as a string like "1m30s" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Source describes a single upstream feed.
+type Source struct {
+	Name         SourceName `json:"name"`
+	URL          URL        `json:"url"`
+	Format       string     `json:"format"`       // Decoder to use for the feed, "bbk-json" (default), "cap-xml", "cap-atom" or "lhp"
+	PollInterval Duration   `json:"pollInterval"` // Defaults to the -updateDelay flag
+	Enabled      bool       `json:"enabled"`      // Defaults to true
+}
+
+// UnmarshalJSON decodes a source, defaulting Enabled to true if it is missing.
+func (s *Source) UnmarshalJSON(b []byte) error {
+	type plain Source
+	v := plain{Enabled: true}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*s = Source(v)
+	return nil
+}
+
+// interval returns the poll interval of s, falling back to the global update delay.
+func (s Source) interval() time.Duration {
+	if s.PollInterval <= 0 {
+		return _updateDelay
+	}
+	return time.Duration(s.PollInterval)
+}
+
+func (s Source) validate() error {
+	if s.Name == "" {
+		return errors.New("source has no name")
+	}
+	if s.URL == "" {
+		return fmt.Errorf("source %s has no URL", s.Name)
+	}
+	if _, ok := decoders[s.Format]; !ok {
+		return fmt.Errorf("source %s has unknown format %q", s.Name, s.Format)
+	}
+	return nil
+}
+
+// sourceConfig is the layout of the file passed with -sourceConfig:
+//
+//	{
+//	    "sources": [
+//	        {"name": "mowas", "url": "https://warnung.bund.de/bbk.mowas/gefahrendurchsagen.json", "pollInterval": "1m"},
+//	        {"name": "mirror", "url": "http://localhost:8081/dwd.json", "format": "bbk-json", "enabled": false}
+//	    ]
+//	}
+type sourceConfig struct {
+	Sources []Source `json:"sources"`
+}
+
+// sourceFlags collects sources given with repeated -source name=url flags.
+type sourceFlags []Source
+
+func (f *sourceFlags) String() string {
+	if f == nil {
+		return ""
+	}
+	var parts []string
+	for _, s := range *f {
+		parts = append(parts, fmt.Sprintf("%s=%s", s.Name, s.URL))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *sourceFlags) Set(v string) error {
+	kv := strings.SplitN(v, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return fmt.Errorf("invalid source %q, want name=url", v)
+	}
+	*f = append(*f, Source{
+		Name:    SourceName(kv[0]),
+		URL:     URL(kv[1]),
+		Format:  formatBBKJSON,
+		Enabled: true,
+	})
+	return nil
+}
+
+// loadSources builds the source registry. Sources from the config file at path come first, followed by sources given as flags.
+// If neither is present, the default warnung.bund.de feeds are used. Disabled sources are dropped.
+func loadSources(path string, flagged []Source) ([]Source, error) {
+	var all []Source
+
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var cfg sourceConfig
+		if err := json.Unmarshal(content, &cfg); err != nil {
+			return nil, fmt.Errorf("parsing source config %s: %w", path, err)
+		}
+		all = append(all, cfg.Sources...)
+	}
+	all = append(all, flagged...)
+
+	if len(all) == 0 {
+		all = defaultSources
+	}
+
+	seen := make(map[SourceName]bool)
+	var sources []Source
+	for _, s := range all {
+		if s.Format == "" {
+			s.Format = formatBBKJSON
+		}
+		if err := s.validate(); err != nil {
+			return nil, err
+		}
+		if seen[s.Name] {
+			return nil, fmt.Errorf("duplicate source name %s", s.Name)
+		}
+		seen[s.Name] = true
+		if s.Enabled {
+			sources = append(sources, s)
+		}
+	}
+
+	return sources, nil
+}
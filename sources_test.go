@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeSourceConfig writes a source config to a temporary file and returns its path and a function removing it.
+func writeSourceConfig(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "sources")
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	path := filepath.Join(dir, "sources.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal("unexpected error", err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadSources(t *testing.T) {
+	path, remove := writeSourceConfig(t, `{"sources": [
+		{"name": "mowas", "url": "https://example.com/mowas.json", "pollInterval": "1m"},
+		{"name": "mirror", "url": "http://localhost:8081/dwd.json", "format": "cap-xml", "enabled": false},
+		{"name": "atom", "url": "https://example.com/index.atom", "format": "cap-atom", "enabled": true}
+	]}`)
+	defer remove()
+
+	var flagged sourceFlags
+	if err := flagged.Set("local=http://localhost:8082/alerts.json"); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	sources, err := loadSources(path, flagged)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	expected := []Source{
+		{Name: "mowas", URL: "https://example.com/mowas.json", Format: formatBBKJSON, PollInterval: Duration(time.Minute), Enabled: true},
+		{Name: "atom", URL: "https://example.com/index.atom", Format: formatCAPAtom, Enabled: true},
+		{Name: "local", URL: "http://localhost:8082/alerts.json", Format: formatBBKJSON, Enabled: true},
+	}
+	if !reflect.DeepEqual(sources, expected) {
+		t.Errorf("unexpected sources\n%v\n%v", sources, expected)
+	}
+
+	if sources, err := loadSources("", nil); err != nil || !reflect.DeepEqual(sources, defaultSources) {
+		t.Error("expected default sources, got", sources, err)
+	}
+}
+
+func TestLoadSourcesErrors(t *testing.T) {
+	testCases := []struct {
+		config  string
+		flagged []string
+	}{
+		{`{"sources": [{"name": "a", "url": "http://a"}, {"name": "a", "url": "http://b", "enabled": false}]}`, nil},
+		{`{"sources": [{"name": "a", "url": "http://a"}]}`, []string{"a=http://b"}},
+		{`{"sources": [{"name": "a", "url": "http://a", "format": "rss"}]}`, nil},
+		{`{"sources": [{"name": "a"}]}`, nil},
+		{`{"sources": [{"url": "http://a"}]}`, nil},
+		{`{"sources": [{"name": "a", "url": "http://a", "pollInterval": 60}]}`, nil},
+		{`{"sources": [{"name": "a", "url": "http://a", "pollInterval": "1 minute"}]}`, nil},
+		{`{"sources": {}}`, nil},
+	}
+	for _, testCase := range testCases {
+		path, remove := writeSourceConfig(t, testCase.config)
+		var flagged sourceFlags
+		for _, f := range testCase.flagged {
+			if err := flagged.Set(f); err != nil {
+				t.Fatal("unexpected error", err)
+			}
+		}
+		if _, err := loadSources(path, flagged); err == nil {
+			t.Errorf("%s %v: expected error", testCase.config, testCase.flagged)
+		}
+		remove()
+	}
+
+	if _, err := loadSources("testdata/missing.json", nil); err == nil {
+		t.Error("expected error for missing config file")
+	}
+}
+
+func TestSourceFlags(t *testing.T) {
+	var f sourceFlags
+	for _, v := range []string{"a=http://a", "b=http://b?x=1"} {
+		if err := f.Set(v); err != nil {
+			t.Fatal("unexpected error", err)
+		}
+	}
+	if len(f) != 2 || f[1].Name != "b" || f[1].URL != "http://b?x=1" || f[1].Format != formatBBKJSON || !f[1].Enabled {
+		t.Error("unexpected sources", f)
+	}
+	if s := f.String(); s != "a=http://a,b=http://b?x=1" {
+		t.Error("unexpected string", s)
+	}
+
+	for _, v := range []string{"", "a", "=http://a", "a="} {
+		if err := f.Set(v); err == nil {
+			t.Errorf("%q: expected error", v)
+		}
+	}
+}
+
+func TestSourceInterval(t *testing.T) {
+	defer func(delay time.Duration) { _updateDelay = delay }(_updateDelay)
+	_updateDelay = 42 * time.Second
+
+	if d := (Source{}).interval(); d != _updateDelay {
+		t.Error("expected -updateDelay without pollInterval, got", d)
+	}
+	if d := (Source{PollInterval: Duration(time.Minute)}).interval(); d != time.Minute {
+		t.Error("expected pollInterval, got", d)
+	}
+
+	var d Duration
+	if err := json.Unmarshal([]byte(`"1m30s"`), &d); err != nil || d != Duration(90*time.Second) {
+		t.Error("unexpected duration", d, err)
+	}
+	if encoded, _ := json.Marshal(d); string(encoded) != `"1m30s"` {
+		t.Error("unexpected encoding", string(encoded))
+	}
+}